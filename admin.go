@@ -0,0 +1,105 @@
+package httpmock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+// adminPrefix is the path prefix of the admin endpoints, which take precedence over the interactions:
+// GET /__admin/requests returns the request log, POST /__admin/reset resets the server and
+// POST /__admin/interactions registers the InteractionSpec, or array of them, in the request body.
+const adminPrefix = "/__admin"
+
+// LoggedRequest is an entry of the log of requests received by the server.
+type LoggedRequest struct {
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query,omitempty"`
+	Headers  http.Header `json:"headers"`
+	Body     string      `json:"body"`
+	Matched  bool        `json:"matched"`
+	Received time.Time   `json:"timestamp"`
+}
+
+func (s *Server) registerAdminRoutes(router *gin.Engine) {
+	admin := router.Group(adminPrefix)
+	admin.GET("/requests", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.RequestLog())
+	})
+	admin.POST("/reset", func(c *gin.Context) {
+		s.Reset()
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/interactions", s.adminAddInteractions)
+}
+
+// adminAddInteractions registers the InteractionSpec or JSON array of InteractionSpec in the request body.
+func (s *Server) adminAddInteractions(c *gin.Context) {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	var specs []InteractionSpec
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var spec InteractionSpec
+		err = jsoniter.Unmarshal(body, &spec)
+		specs = []InteractionSpec{spec}
+	} else {
+		err = jsoniter.Unmarshal(body, &specs)
+	}
+	if err == nil {
+		err = s.addSpecs(specs)
+	}
+	if err != nil {
+		s.logger.Warn("rejecting interactions registered over HTTP", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse{
+			Message: "[MOCK WEB SERVER ERROR] invalid interactions: " + err.Error(),
+			Path:    c.Request.URL.Path,
+			Method:  c.Request.Method,
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, specs)
+}
+
+// RequestLog returns every request received by the server, matched or not, in the order they arrived.
+// It is also served as JSON by GET /__admin/requests.
+func (s *Server) RequestLog() []LoggedRequest {
+	s.requestLogLock.Lock()
+	defer s.requestLogLock.Unlock()
+	log := make([]LoggedRequest, 0, len(s.requestLog))
+	for _, logged := range s.requestLog {
+		log = append(log, *logged)
+	}
+	return log
+}
+
+// logRequest appends the request to the request log as soon as it arrives, so that requests rejected before
+// matching are logged too. Its body and match result are filled in by setLogged once known.
+func (s *Server) logRequest(c *gin.Context, received time.Time) *LoggedRequest {
+	logged := &LoggedRequest{
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Query:    c.Request.URL.RawQuery,
+		Headers:  c.Request.Header.Clone(),
+		Received: received,
+	}
+	s.requestLogLock.Lock()
+	defer s.requestLogLock.Unlock()
+	s.requestLog = append(s.requestLog, logged)
+	return logged
+}
+
+func (s *Server) setLogged(logged *LoggedRequest, body []byte, matched bool) {
+	s.requestLogLock.Lock()
+	defer s.requestLogLock.Unlock()
+	logged.Body = string(body)
+	logged.Matched = matched
+}
@@ -0,0 +1,36 @@
+package httpmock
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WithBasicAuth answers requests without the user and password as basic auth credentials with 401 Unauthorized and
+// a WWW-Authenticate challenge, before any interaction is matched. CORS preflight requests are not checked.
+func (s *Server) WithBasicAuth(user string, pass string) *Server {
+	s.basicAuth = &basicAuth{user: user, pass: pass}
+	return s
+}
+
+type basicAuth struct {
+	user string
+	pass string
+}
+
+// authorized reports whether the request may reach the interactions, responding with 401 if not.
+func (s *Server) authorized(c *gin.Context) bool {
+	if s.basicAuth == nil {
+		return true
+	}
+	user, pass, ok := c.Request.BasicAuth()
+	if ok && subtle.ConstantTimeCompare([]byte(user), []byte(s.basicAuth.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(s.basicAuth.pass)) == 1 {
+		return true
+	}
+	s.logger.Warn("responding with 401 since the request lacks valid basic auth credentials")
+	c.Header("WWW-Authenticate", `Basic realm="httpmock"`)
+	c.Status(http.StatusUnauthorized)
+	return false
+}
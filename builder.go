@@ -0,0 +1,74 @@
+package httpmock
+
+import (
+	"github.com/httpmock/option"
+	"net/http"
+	"time"
+)
+
+// InteractionBuilder builds an interaction step by step; see Server.On.
+type InteractionBuilder struct {
+	server      *Server
+	method      string
+	path        string
+	status      int
+	object      interface{}
+	contentType string
+	captureFunc RequestCaptureFunc
+	headers     http.Header
+	options     []option.HttpMockOptionFunc
+}
+
+// On starts building an interaction for the method and path, which responds with 200 OK and no body
+// until configured otherwise. Nothing is added to the server before Register is called.
+func (s *Server) On(method string, path string) *InteractionBuilder {
+	return &InteractionBuilder{server: s, method: method, path: path, status: http.StatusOK, contentType: "JSON"}
+}
+
+// RespondWith sets the response status and the object rendered as response body.
+func (b *InteractionBuilder) RespondWith(status int, obj interface{}) *InteractionBuilder {
+	b.status = status
+	b.object = obj
+	return b
+}
+
+// ContentType sets the content type of the response, see AddInteraction. It defaults to JSON.
+func (b *InteractionBuilder) ContentType(contentType string) *InteractionBuilder {
+	b.contentType = contentType
+	return b
+}
+
+// WithHeader adds a response header.
+func (b *InteractionBuilder) WithHeader(name string, value string) *InteractionBuilder {
+	if b.headers == nil {
+		b.headers = http.Header{}
+	}
+	b.headers.Add(name, value)
+	return b
+}
+
+// Delay delays the response, like option.WithResponseDelay.
+func (b *InteractionBuilder) Delay(delay time.Duration) *InteractionBuilder {
+	return b.With(option.WithResponseDelay(delay))
+}
+
+// Capture calls fn with the body and headers of every request answered by the interaction.
+func (b *InteractionBuilder) Capture(fn RequestCaptureFunc) *InteractionBuilder {
+	b.captureFunc = fn
+	return b
+}
+
+// With applies any further options to the interaction.
+func (b *InteractionBuilder) With(opts ...option.HttpMockOptionFunc) *InteractionBuilder {
+	b.options = append(b.options, opts...)
+	return b
+}
+
+// Register adds the interaction to the server.
+func (b *InteractionBuilder) Register() {
+	opts := b.options
+	if len(b.headers) > 0 {
+		opts = append(opts, option.WithResponseHeaders(b.headers))
+	}
+	b.server.AddInteraction(b.method, b.path, b.status, b.object, b.contentType, b.captureFunc, opts...)
+}
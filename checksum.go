@@ -0,0 +1,46 @@
+package httpmock
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"github.com/httpmock/option"
+	"hash"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// withChecksum returns responseObject as a JSON object with the digest of body added under field.
+// A nil response object results in an object containing only the checksum.
+func withChecksum(responseObject interface{}, field string, algo string, body []byte) (map[string]interface{}, error) {
+	var h hash.Hash
+	switch algo {
+	case option.ChecksumMD5:
+		h = md5.New()
+	case option.ChecksumSHA1:
+		h = sha1.New()
+	case option.ChecksumSHA256:
+		h = sha256.New()
+	case option.ChecksumSHA512:
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	h.Write(body)
+
+	obj := map[string]interface{}{}
+	if responseObject != nil {
+		raw, err := jsoniter.Marshal(responseObject)
+		if err != nil {
+			return nil, err
+		}
+		if err := jsoniter.Unmarshal(raw, &obj); err != nil {
+			return nil, fmt.Errorf("response object must be a JSON object to add a checksum: %w", err)
+		}
+	}
+	obj[field] = hex.EncodeToString(h.Sum(nil))
+	return obj, nil
+}
@@ -0,0 +1,51 @@
+package httpmock
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// WithCORS answers CORS preflight requests from the origins and adds the Access-Control-Allow-* headers to the
+// responses to their actual requests. The origin "*" allows every origin.
+func (s *Server) WithCORS(origins []string) *Server {
+	s.corsOrigins = append([]string(nil), origins...)
+	return s
+}
+
+// cors adds the CORS headers for allowed origins and returns whether the request was a preflight that has been answered.
+func (s *Server) cors(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if len(s.corsOrigins) == 0 || origin == "" || !s.corsAllowed(origin) {
+		return false
+	}
+
+	header := c.Writer.Header()
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+
+	requestMethod := c.GetHeader("Access-Control-Request-Method")
+	if c.Request.Method != http.MethodOptions || requestMethod == "" {
+		header.Set("Access-Control-Allow-Methods", c.Request.Method)
+		return false
+	}
+
+	header.Set("Access-Control-Allow-Methods", requestMethod)
+	if requestHeaders := c.GetHeader("Access-Control-Request-Headers"); requestHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestHeaders)
+	}
+	s.logger.Info("responding to CORS preflight", zap.String("origin", origin), zap.String("method", requestMethod))
+	c.Status(http.StatusNoContent)
+	return true
+}
+
+func (s *Server) corsAllowed(origin string) bool {
+	for _, allowed := range s.corsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package httpmock
+
+import (
+	"github.com/httpmock/option"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// writeFault hijacks the connection and closes it without writing a valid HTTP response.
+// For option.FaultConnectionReset the socket lingers for 0 seconds, so the client sees a TCP reset
+// instead of a clean end of stream.
+func (s *Server) writeFault(c *gin.Context, fault option.FaultType) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		s.logger.Error("failed to hijack connection to inject fault", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("injecting fault", zap.Int("fault", int(fault)))
+	if fault == option.FaultConnectionReset {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.SetLinger(0)
+		}
+	}
+	_ = conn.Close()
+}
+
+// hang blocks without responding until the client gives up or the server shuts down,
+// in which case the connection is closed so that shutdown does not wait for the request.
+func (s *Server) hang(c *gin.Context) {
+	s.logger.Info("hanging without responding")
+	select {
+	case <-c.Request.Context().Done():
+		s.logger.Info("client gave up on hanging request")
+	case <-s.closing:
+		s.logger.Info("closing hanging request since server is shutting down")
+		s.writeFault(c, option.FaultEmptyResponse)
+	}
+}
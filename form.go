@@ -0,0 +1,67 @@
+package httpmock
+
+import (
+	"bytes"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const defaultMultipartMaxMemory = 32 << 20
+
+// CapturedForm holds the fields and files of a captured multipart/form-data request.
+type CapturedForm struct {
+	Values url.Values
+	Files  map[string][]CapturedFile
+}
+
+// CapturedFile describes a file part of a captured multipart/form-data request.
+type CapturedFile struct {
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+// WithMultipartMaxMemory limits the bytes of multipart/form-data file parts kept in memory while parsing captured
+// forms to n; the rest is buffered in temporary files. It defaults to 32 MB.
+func (s *Server) WithMultipartMaxMemory(n int64) *Server {
+	s.multipartMaxMemory = n
+	return s
+}
+
+// parseForm returns the captured form of a multipart/form-data request, or nil for other content types.
+func (s *Server) parseForm(body []byte, headers http.Header) *CapturedForm {
+	mediaType, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
+	if err != nil || !strings.EqualFold(mediaType, "multipart/form-data") || params["boundary"] == "" {
+		return nil
+	}
+
+	maxMemory := s.multipartMaxMemory
+	if maxMemory <= 0 {
+		maxMemory = defaultMultipartMaxMemory
+	}
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(maxMemory)
+	if err != nil {
+		s.logger.Warn("failed to parse multipart form", zap.Error(err))
+		return nil
+	}
+	defer func() {
+		_ = form.RemoveAll()
+	}()
+
+	captured := &CapturedForm{Values: url.Values(form.Value), Files: make(map[string][]CapturedFile, len(form.File))}
+	for name, files := range form.File {
+		for _, file := range files {
+			captured.Files[name] = append(captured.Files[name], CapturedFile{
+				Filename:    file.Filename,
+				ContentType: file.Header.Get("Content-Type"),
+				Size:        file.Size,
+			})
+		}
+	}
+	return captured
+}
@@ -0,0 +1,50 @@
+package httpmock
+
+import "net/http"
+
+// WithOnRequest calls fn with every request and its body once the body has been read. Requests answered to
+// "Expect: 100-continue" without reading the body are passed with a nil body.
+// Hooks run in the order they were added.
+func (s *Server) WithOnRequest(fn func(r *http.Request, body []byte)) *Server {
+	s.onRequest = append(s.onRequest, fn)
+	return s
+}
+
+// WithOnMatch calls fn with the interaction matched by a request before it is answered. Interactions registered with
+// a path template or regex are passed as a copy with the PathParams of the request. Hooks run in the order they were added.
+func (s *Server) WithOnMatch(fn func(rr *RequestResponse)) *Server {
+	s.onMatch = append(s.onMatch, fn)
+	return s
+}
+
+// WithOnNoMatch calls fn with every request no interaction was found for, before static directories or the upstream
+// server are tried. Hooks run in the order they were added.
+func (s *Server) WithOnNoMatch(fn func(r *http.Request)) *Server {
+	s.onNoMatch = append(s.onNoMatch, fn)
+	return s
+}
+
+// runRequestHooks runs the OnRequest hooks for a request and its body.
+func (s *Server) runRequestHooks(r *http.Request, body []byte) {
+	for _, hook := range s.onRequest {
+		hook(r, body)
+	}
+}
+
+// runMatchHooks runs the OnMatch hooks for a matched interaction and the OnNoMatch hooks if mock is nil.
+func (s *Server) runMatchHooks(r *http.Request, mock *RequestResponse, pathParams map[string]string) {
+	if mock == nil {
+		for _, hook := range s.onNoMatch {
+			hook(r)
+		}
+		return
+	}
+	if pathParams != nil {
+		matched := s.Interactions.snapshot(mock)
+		matched.PathParams = pathParams
+		mock = &matched
+	}
+	for _, hook := range s.onMatch {
+		hook(mock)
+	}
+}
@@ -0,0 +1,124 @@
+package httpmock
+
+import (
+	"fmt"
+	"github.com/httpmock/option"
+	"net/http"
+	"os"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// InteractionSpec is the declarative form of an interaction used by LoadFromFile and Export.
+type InteractionSpec struct {
+	Method      string       `json:"method"`
+	Path        string       `json:"path"`
+	Status      int          `json:"status"`
+	Body        interface{}  `json:"body,omitempty"`
+	ContentType string       `json:"contentType,omitempty"`
+	Headers     http.Header  `json:"headers,omitempty"`
+	DelayMs     int64        `json:"delayMs,omitempty"`
+	Captured    *CaptureSpec `json:"captured,omitempty"`
+}
+
+// CaptureSpec is the request captured by an interaction. It is exported for inspection and ignored when loading.
+type CaptureSpec struct {
+	Body    string      `json:"body"`
+	Headers http.Header `json:"headers,omitempty"`
+}
+
+// Export serializes all registered interactions, including the requests they captured, as a JSON array of
+// InteractionSpec that can be loaded again with LoadFromFile.
+func (m *Interactions) Export() ([]byte, error) {
+	m.lock.Lock()
+	specs := make([]InteractionSpec, 0, len(m.interactions))
+	for _, key := range m.sortedKeys() {
+		for _, rr := range m.interactions[key].requestResponses {
+			specs = append(specs, newInteractionSpec(rr))
+		}
+	}
+	m.lock.Unlock()
+
+	return jsoniter.MarshalIndent(specs, "", "  ")
+}
+
+func newInteractionSpec(rr *RequestResponse) InteractionSpec {
+	spec := InteractionSpec{
+		Method:      rr.Method,
+		Path:        rr.Path,
+		Status:      rr.ResponseHttpStatus,
+		Body:        rr.ResponseObject,
+		ContentType: rr.ResponseContentType,
+		Headers:     rr.ResponseHeaders,
+		DelayMs:     rr.DelayResponse.Milliseconds(),
+	}
+	if rr.CapturedRequestBody != nil || rr.CapturedRequestHeaders != nil {
+		spec.Captured = &CaptureSpec{Body: string(rr.CapturedRequestBody), Headers: rr.CapturedRequestHeaders}
+	}
+	return spec
+}
+
+// LoadFromFile registers the interactions described by the JSON array of InteractionSpec in the file at path.
+// Unknown fields are ignored. Nothing is registered if the file can't be read or parsed or a spec is invalid.
+func (s *Server) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read interactions file %s: %w", path, err)
+	}
+
+	var specs []InteractionSpec
+	if err := jsoniter.Unmarshal(data, &specs); err != nil {
+		return fmt.Errorf("failed to parse interactions file %s: %w", path, err)
+	}
+	if err := s.addSpecs(specs); err != nil {
+		return fmt.Errorf("%w in %s", err, path)
+	}
+	s.logger.Sugar().Infof("loaded %d interactions from %s", len(specs), path)
+	return nil
+}
+
+// addSpecs registers the interactions of the specs, or none if a spec is invalid.
+func (s *Server) addSpecs(specs []InteractionSpec) error {
+	for i, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return fmt.Errorf("invalid interaction %d: %w", i, err)
+		}
+	}
+	for _, spec := range specs {
+		s.AddInteraction(spec.Method, spec.Path, spec.Status, spec.Body, spec.contentType(), nil, spec.options()...)
+	}
+	return nil
+}
+
+func (spec InteractionSpec) validate() error {
+	switch {
+	case spec.Method == "":
+		return fmt.Errorf("method is required")
+	case spec.Path == "":
+		return fmt.Errorf("path is required")
+	case spec.Status < 100 || spec.Status > 999:
+		return fmt.Errorf("status %d is not a valid HTTP status code", spec.Status)
+	case spec.DelayMs < 0:
+		return fmt.Errorf("delayMs must not be negative")
+	}
+	return nil
+}
+
+func (spec InteractionSpec) contentType() string {
+	if spec.ContentType == "" {
+		return "JSON"
+	}
+	return spec.ContentType
+}
+
+func (spec InteractionSpec) options() []option.HttpMockOptionFunc {
+	var opts []option.HttpMockOptionFunc
+	if spec.DelayMs > 0 {
+		opts = append(opts, option.WithResponseDelay(time.Duration(spec.DelayMs)*time.Millisecond))
+	}
+	if len(spec.Headers) > 0 {
+		opts = append(opts, option.WithResponseHeaders(spec.Headers))
+	}
+	return opts
+}
@@ -0,0 +1,56 @@
+package httpmock
+
+import (
+	"net/http"
+	"sort"
+)
+
+// WithAutoHead answers HEAD requests without a matching HEAD interaction with the GET interaction for the same path,
+// using its status and headers but no body. The GET interaction is consumed as if it had been called.
+func (s *Server) WithAutoHead() *Server {
+	s.autoHead = true
+	return s
+}
+
+// nextMatchingInteraction returns the interaction matching the request, falling back to GET interactions for HEAD
+// requests if WithAutoHead is used, together with the path parameters of the request. Interactions the request is
+// rejected by are returned unconsumed with the reason.
+func (s *Server) nextMatchingInteraction(r *http.Request, body []byte, mode matchMode) (*RequestResponse, map[string]string, rejection) {
+	mock, params, reason := s.Interactions.nextMatchingInteraction(r, body, mode)
+	if mock == nil && s.autoHead && r.Method == http.MethodHead {
+		get := r.Clone(r.Context())
+		get.Method = http.MethodGet
+		mock, params, reason = s.Interactions.nextMatchingInteraction(get, body, mode)
+	}
+	return mock, params, reason
+}
+
+// WithAutoOptions answers OPTIONS requests without a matching OPTIONS interaction with 204 and an Allow header
+// listing the methods of all interactions registered for the path.
+func (s *Server) WithAutoOptions() *Server {
+	s.autoOptions = true
+	return s
+}
+
+// allowedMethods returns the methods for the Allow header of an unmatched OPTIONS request if WithAutoOptions is used,
+// nil if no interactions are registered for its path.
+func (s *Server) allowedMethods(r *http.Request) []string {
+	if !s.autoOptions || r.Method != http.MethodOptions {
+		return nil
+	}
+	registered := s.Interactions.methods(r.URL.Path)
+	if len(registered) == 0 {
+		return nil
+	}
+	methods := []string{http.MethodOptions}
+	for _, method := range registered {
+		if method == http.MethodGet && s.autoHead && !contains(registered, http.MethodHead) {
+			methods = append(methods, http.MethodHead)
+		}
+		if method != http.MethodOptions {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
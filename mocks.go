@@ -1,27 +1,61 @@
 package httpmock
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/httpmock/option"
+	"io"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	"go.uber.org/zap"
 )
 
 type Interactions struct {
 	interactions map[string]*interactions
+	patternKeys  []string
+	scenarios    map[string]string
+	called       *sync.Cond
 	lock         sync.RWMutex
 	logger       *zap.Logger
 }
 
 type interactions struct {
 	attempt          int
-	requestResponses []RequestResponse
+	requestResponses []*RequestResponse
+	arrivals         []time.Time
+	expectedCalls    *int
+	inFlight         chan struct{}
+	method           string
+	pathRegex        *regexp.Regexp
+	rateLimitCalls   int
+	lastCaptured     *RequestResponse
+	requestSchema    interface{}
+	captureLimit     int
+	history          []CapturedRequest
+	historyNext      int
+	capturedOrder    []*RequestResponse
 }
 
+const rateWindow = time.Second
+
 type RequestCaptureFunc func(capturedRequestBody []byte, capturedRequestHeaders http.Header)
 
+// RequestCaptureFuncV2 additionally receives the parameters extracted from a templated path such as /users/:id.
+type RequestCaptureFuncV2 func(capturedRequestBody []byte, capturedRequestHeaders http.Header, pathParams map[string]string)
+
+// ResponseFunc computes the response status, object and additional headers from the received request.
+type ResponseFunc func(req *http.Request, body []byte) (status int, obj interface{}, headers http.Header)
+
 type RequestResponse struct {
 	Path                   string
 	Method                 string
@@ -30,8 +64,58 @@ type RequestResponse struct {
 	ResponseContentType    string
 	CapturedRequestBody    []byte
 	CapturedRequestHeaders http.Header
+	RawCapturedRequestBody []byte
+	CapturedQuery          url.Values
+	CapturedURL            string
+	CapturedForm           *CapturedForm
+	CapturedAt             time.Time
+	Elapsed                time.Duration
 	DelayResponse          time.Duration
+	RandomDelayMin         time.Duration
+	RandomDelayMax         time.Duration
 	RequestCaptureFunc     RequestCaptureFunc
+	RequestBody            []byte
+	CloseDelimited         bool
+	ReasonPhrase           string
+	ServerTiming           map[string]time.Duration
+	DegradeAboveRate       float64
+	ChecksumField          string
+	ChecksumAlgo           string
+	ExpectContinue         option.ExpectContinueBehavior
+	RangeRequests          bool
+	ConcurrencyLimit       int
+	Overflow               option.ConcurrencyOverflow
+	QueryParams            map[string]string
+	RequestHeaders         http.Header
+	BodyMatcher            func(body []byte) bool
+	RequestCaptureFuncV2   RequestCaptureFuncV2
+	PathParams             map[string]string
+	RawResponseBody        []byte
+	ResponseBodyFile       string
+	ResponseReader         func() io.Reader
+	Priority               int
+	ResponseFunc           ResponseFunc
+	Times                  int
+	Persistent             bool
+	ResponseHeaders        http.Header
+	ResponseCookies        []*http.Cookie
+	RedirectLocation       string
+	Fault                  option.FaultType
+	RateLimit              *int
+	RetryAfter             time.Duration
+	Scenario               string
+	RequiredState          string
+	NewState               string
+	Hang                   bool
+	SSEEvents              []option.SSEEvent
+	StreamedChunks         [][]byte
+	StreamInterval         time.Duration
+	GzipResponse           bool
+	ResponseTemplate       *template.Template
+	Representations        map[string]interface{}
+	calls                  int
+	consumed               bool
+	captured               bool
 }
 
 func NewInteractions(logger *zap.Logger) *Interactions {
@@ -41,9 +125,11 @@ func NewInteractions(logger *zap.Logger) *Interactions {
 
 	mi := &Interactions{
 		interactions: make(map[string]*interactions),
+		scenarios:    make(map[string]string),
 		lock:         sync.RWMutex{},
 		logger:       logger,
 	}
+	mi.called = sync.NewCond(&mi.lock)
 	mi.logger.Info("created new instance of Interactions")
 	return mi
 }
@@ -59,9 +145,92 @@ func NewRequestResponse(method string, path string, responseStatus int, response
 	}
 
 	addDelay(&req, opts)
+	req.RequestBody = opts.RequestBody
+	req.CloseDelimited = opts.CloseDelimited
+	req.ReasonPhrase = opts.ReasonPhrase
+	req.ServerTiming = opts.ServerTiming
+	req.DegradeAboveRate = opts.DegradeAboveRate
+	req.ChecksumField = opts.ChecksumField
+	req.ChecksumAlgo = opts.ChecksumAlgo
+	req.ExpectContinue = opts.ExpectContinue
+	req.RangeRequests = opts.RangeRequests
+	req.ConcurrencyLimit = opts.ConcurrencyLimit
+	req.Overflow = opts.Overflow
+	req.QueryParams = opts.QueryParams
+	req.RequestHeaders = opts.RequestHeaders
+	req.BodyMatcher = opts.BodyMatcher
+	req.RequestCaptureFuncV2 = opts.CaptureFuncV2
+	req.RawResponseBody = opts.RawResponseBody
+	req.ResponseBodyFile = opts.ResponseBodyFile
+	req.ResponseReader = opts.ResponseReader
+	req.Priority = opts.Priority
+	if opts.FormResponse != nil {
+		req.ResponseObject = opts.FormResponse
+		req.ResponseContentType = "application/x-www-form-urlencoded"
+	}
+	req.ResponseFunc = opts.ResponseFunc
+	req.Persistent = opts.Persistent
+	req.ResponseHeaders = opts.ResponseHeaders
+	req.ResponseCookies = opts.ResponseCookies
+	req.RedirectLocation = opts.RedirectLocation
+	req.Fault = opts.Fault
+	req.Scenario = opts.Scenario
+	req.RequiredState = opts.RequiredState
+	req.NewState = opts.NewState
+	req.Hang = opts.Hang
+	req.SSEEvents = opts.SSEEvents
+	req.StreamedChunks = opts.StreamedChunks
+	req.StreamInterval = opts.StreamInterval
+	req.GzipResponse = opts.GzipResponse
+	req.ResponseTemplate = opts.ResponseTemplate
+	req.Representations = opts.Representations
+	if opts.RateLimited {
+		rateLimit := opts.RateLimit
+		req.RateLimit = &rateLimit
+		req.RetryAfter = opts.RetryAfter
+	}
+	req.Times = 1
+	if opts.Times > 0 {
+		req.Times = opts.Times
+	}
+	if len(opts.AttemptResponses) > 0 {
+		addAttemptResponses(&req, opts)
+	}
 	return req
 }
 
+// addAttemptResponses answers the attempts of the interaction with their own responses and keeps the interaction
+// in use until the last attempt, or forever when it repeats the last attempt.
+func addAttemptResponses(req *RequestResponse, opts option.HttpMockOptions) {
+	last := 0
+	for attempt := range opts.AttemptResponses {
+		if attempt > last {
+			last = attempt
+		}
+	}
+	if opts.RepeatLastAttempt {
+		req.Persistent = true
+	} else if req.Times < last+1 {
+		req.Times = last + 1
+	}
+
+	var calls int32
+	status, obj, next := req.ResponseHttpStatus, req.ResponseObject, req.ResponseFunc
+	req.ResponseFunc = func(r *http.Request, body []byte) (int, interface{}, http.Header) {
+		attempt := int(atomic.AddInt32(&calls, 1) - 1)
+		if attempt > last && opts.RepeatLastAttempt {
+			attempt = last
+		}
+		if response, ok := opts.AttemptResponses[attempt]; ok {
+			return response.Status, response.Object, nil
+		}
+		if next != nil {
+			return next(r, body)
+		}
+		return status, obj, nil
+	}
+}
+
 func (m *Interactions) Add(method string, path string, responseStatus int, responseObject interface{}, responseContentType string, requestCaptureFunc RequestCaptureFunc, opts ...option.HttpMockOptionFunc) *Interactions {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -71,36 +240,284 @@ func (m *Interactions) Add(method string, path string, responseStatus int, respo
 	if !ok {
 		mi = &interactions{
 			attempt:          0,
-			requestResponses: make([]RequestResponse, 0, 10),
+			requestResponses: make([]*RequestResponse, 0, 10),
 		}
 		m.logger.Debug("adding interaction for key: " + key)
 	}
 	m.logger.Info("adding mock interaction", zap.String("method", method), zap.String("path", path), zap.Int("responseStatus", responseStatus))
 
 	options := option.ProcessOptions(m.logger, opts)
+	pathRegex := options.PathRegex
+	if pathRegex == nil && isPathTemplate(path) {
+		var err error
+		if pathRegex, err = pathTemplateRegex(path); err != nil {
+			m.logger.Panic("add interaction failed", zap.Error(err))
+		}
+	}
+	if pathRegex != nil {
+		if mi.pathRegex == nil {
+			m.patternKeys = append(m.patternKeys, key)
+		}
+		mi.method = method
+		mi.pathRegex = pathRegex
+	}
+	if options.ExpectedCalls != nil {
+		mi.expectedCalls = options.ExpectedCalls
+	}
+	if options.CaptureLimit > 0 {
+		mi.captureLimit = options.CaptureLimit
+	}
+	if options.RequestSchema != nil {
+		_ = jsoniter.Unmarshal(options.RequestSchema, &mi.requestSchema)
+	}
+	if options.ConcurrencyLimit > 0 && cap(mi.inFlight) != options.ConcurrencyLimit {
+		mi.inFlight = make(chan struct{}, options.ConcurrencyLimit)
+	}
 
 	req := NewRequestResponse(method, path, responseStatus, responseObject, responseContentType, requestCaptureFunc, options)
 
-	mi.requestResponses = append(mi.requestResponses, req)
+	mi.requestResponses = append(mi.requestResponses, &req)
 	m.interactions[key] = mi
 
 	return m
 }
 
-func (m *Interactions) NextInteraction(method string, path string) *RequestResponse {
+// Remove drops all interactions for the method and path.
+func (m *Interactions) Remove(method string, path string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.remove(getKey(method, path))
+	m.logger.Info("removed mock interactions", zap.String("method", method), zap.String("path", path))
+}
+
+// ResetKey clears the interactions of the method and path together with their call count,
+// leaving the interactions of other paths intact. The call count is kept with the interactions, so this is Remove.
+func (m *Interactions) ResetKey(method string, path string) {
+	m.Remove(method, path)
+}
+
+func (m *Interactions) remove(key string) {
+	delete(m.interactions, key)
+	for i, patternKey := range m.patternKeys {
+		if patternKey == key {
+			m.patternKeys = append(m.patternKeys[:i:i], m.patternKeys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Replace swaps all interactions for the method and path with rr, keeping the settings of the path such as
+// its expected calls and concurrency limit.
+func (m *Interactions) Replace(method string, path string, rr RequestResponse) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	key := getKey(method, path)
 	mi, ok := m.interactions[key]
-	if !ok || mi.attempt >= len(mi.requestResponses) {
-		m.logger.Warn("no interactions found for key: " + key)
-		return nil
+	if !ok {
+		mi = &interactions{}
+		if isPathTemplate(path) {
+			pathRegex, err := pathTemplateRegex(path)
+			if err != nil {
+				m.logger.Panic("replace interaction failed", zap.Error(err))
+			}
+			mi.method = method
+			mi.pathRegex = pathRegex
+			m.patternKeys = append(m.patternKeys, key)
+		}
+		m.interactions[key] = mi
 	}
+	rr.Method = method
+	rr.Path = path
+	rr.calls = 0
+	rr.consumed = false
+	rr.captured = false
+	if rr.Times == 0 {
+		rr.Times = 1
+	}
+	mi.requestResponses = []*RequestResponse{&rr}
+	m.logger.Info("replaced mock interactions", zap.String("method", method), zap.String("path", path), zap.Int("responseStatus", rr.ResponseHttpStatus))
+}
 
-	requestResponse := mi.requestResponses[mi.attempt]
+// addRecorded stores an interaction that was already answered, e.g. by an upstream server.
+func (m *Interactions) addRecorded(rr RequestResponse) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := getKey(rr.Method, rr.Path)
+	mi, ok := m.interactions[key]
+	if !ok {
+		mi = &interactions{requestResponses: make([]*RequestResponse, 0, 10)}
+		m.interactions[key] = mi
+	}
+	rr.calls = 1
+	rr.consumed = true
+	rr.captured = true
+	mi.requestResponses = append(mi.requestResponses, &rr)
 	mi.attempt++
-	return &requestResponse
+	m.logger.Info("recorded interaction", zap.String("method", rr.Method), zap.String("path", rr.Path), zap.Int("responseStatus", rr.ResponseHttpStatus))
+}
+
+func (m *Interactions) NextInteraction(method string, path string) *RequestResponse {
+	return m.NextMatchingInteraction(&http.Request{Method: method, URL: &url.URL{Path: path}, Header: http.Header{}}, nil)
+}
+
+// NextMatchingInteraction returns the next interaction for the request's method and path.
+// Candidates are evaluated by option.WithPriority, highest first. Among interactions of the same priority,
+// interactions with matchers are tried first, in registration order; interactions without matchers are the fallback.
+// Interactions registered for the exact path come before those registered with option.WithPathRegex, which are tried
+// in registration order. Only the chosen interaction is consumed.
+// Selection and consumption happen atomically under the lock, so N concurrent requests for a method and path with
+// N queued interactions consume each interaction exactly once; which request gets which interaction depends on the
+// order in which they take the lock.
+func (m *Interactions) NextMatchingInteraction(r *http.Request, body []byte) *RequestResponse {
+	requestResponse, _, _ := m.nextMatchingInteraction(r, body, matchAny)
+	return requestResponse
+}
+
+// rejection is the reason a matched interaction is not used to answer a request.
+type rejection int
+
+const (
+	notRejected rejection = iota
+	// rejectedDegraded means the request rate is above the threshold of option.WithDegradeAboveRate.
+	rejectedDegraded
+	// rejectedConcurrency means the concurrency limit of option.WithKeyConcurrencyLimit is reached with OverflowReject.
+	rejectedConcurrency
+)
+
+// matchMode controls which interactions nextMatchingInteraction considers and whether it checks them before consuming.
+type matchMode int
+
+const (
+	// matchAny consumes the chosen interaction without further checks.
+	matchAny matchMode = iota
+	// matchAdmitted checks the chosen interaction against its degrade threshold and concurrency limit first.
+	matchAdmitted
+	// matchExpectContinue works like matchAdmitted for a request whose body has not been read. Interactions that need
+	// the body to match are skipped and nothing is consumed if the chosen interaction accepts "Expect: 100-continue".
+	matchExpectContinue
+)
+
+// nextMatchingInteraction works like NextMatchingInteraction and also returns the path parameters of the request if
+// the interaction was registered with a path template or regex. The interaction is shared by all requests it answers,
+// so the parameters are never stored on it. Unless mode is matchAny, the chosen interaction is checked
+// against its degrade threshold and concurrency limit first and is returned unconsumed with the reason if the request
+// must be rejected. An admitted request holds a slot of an OverflowReject concurrency limit, which the caller releases.
+func (m *Interactions) nextMatchingInteraction(r *http.Request, body []byte, mode matchMode) (*RequestResponse, map[string]string, rejection) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := getKey(r.Method, r.URL.Path)
+	var candidates []*interactions
+	exact, hasExact := m.interactions[key]
+	if hasExact {
+		candidates = append(candidates, exact)
+	}
+	for _, patternKey := range m.patternKeys {
+		if mi := m.interactions[patternKey]; patternKey != key && mi.method == r.Method && mi.pathRegex.MatchString(r.URL.Path) {
+			candidates = append(candidates, mi)
+		}
+	}
+
+	arrived := make(map[*interactions]bool, len(candidates))
+	for _, priority := range priorities(candidates) {
+		eligible := func(rr *RequestResponse) bool {
+			return rr.Priority == priority && m.inState(rr) && (mode != matchExpectContinue || !rr.needsBody())
+		}
+		for _, mi := range candidates {
+			if !arrived[mi] {
+				if mode != matchExpectContinue {
+					mi.recordArrival(time.Now())
+				}
+				arrived[mi] = true
+			}
+			index := mi.selectFor(r, body, eligible)
+			if index < 0 {
+				continue
+			}
+			if mode == matchExpectContinue {
+				// the request arrives again once its body is read, so it only counts if it is answered now
+				if mi.requestResponses[index].ExpectContinue == option.ExpectContinueAccept {
+					return nil, nil, notRejected
+				}
+				for arrivedAt := range arrived {
+					arrivedAt.recordArrival(time.Now())
+				}
+			}
+			if mode != matchAny {
+				if reason := mi.admit(mi.requestResponses[index]); reason != notRejected {
+					return mi.requestResponses[index], nil, reason
+				}
+			}
+			if hasExact && mi == exact {
+				return m.consume(mi, index), nil, notRejected
+			}
+			m.logger.Info("matched path regex", zap.String("path", r.URL.Path), zap.String("pattern", mi.pathRegex.String()))
+			return m.consume(mi, index), pathParams(mi.pathRegex, r.URL.Path), notRejected
+		}
+	}
+
+	if mode != matchExpectContinue {
+		m.logger.Warn("no interactions found for key: " + key)
+	}
+	return nil, nil, notRejected
+}
+
+// priorities returns the distinct priorities of the unconsumed interactions, highest first,
+// or the default priority if there are none.
+func priorities(candidates []*interactions) []int {
+	seen := map[int]bool{}
+	var result []int
+	for _, mi := range candidates {
+		for _, rr := range mi.requestResponses {
+			if !rr.consumed && !seen[rr.Priority] {
+				seen[rr.Priority] = true
+				result = append(result, rr.Priority)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return []int{0}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(result)))
+	return result
+}
+
+// methods returns the sorted methods of all interactions registered for the path, consumed or not.
+func (m *Interactions) methods(path string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var methods []string
+	for _, mi := range m.interactions {
+		if len(mi.requestResponses) == 0 {
+			continue
+		}
+		rr := mi.requestResponses[0]
+		if (mi.pathRegex != nil && mi.pathRegex.MatchString(path)) || (mi.pathRegex == nil && rr.Path == path) {
+			methods = append(methods, rr.Method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// requestSchema returns the JSON schema that requests for the method and path must satisfy, nil if there is none.
+func (m *Interactions) requestSchema(r *http.Request) interface{} {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if mi, ok := m.interactions[getKey(r.Method, r.URL.Path)]; ok {
+		return mi.requestSchema
+	}
+	for _, patternKey := range m.patternKeys {
+		if mi := m.interactions[patternKey]; mi.method == r.Method && mi.pathRegex.MatchString(r.URL.Path) {
+			return mi.requestSchema
+		}
+	}
+	return nil
 }
 
 func (m *Interactions) Interaction(method string, path string, attempt int) *RequestResponse {
@@ -112,7 +529,7 @@ func (m *Interactions) Interaction(method string, path string, attempt int) *Req
 	if !ok || attempt >= len(mi.requestResponses) {
 		return nil
 	}
-	return &mi.requestResponses[attempt]
+	return mi.requestResponses[attempt]
 }
 
 func (m *Interactions) AllInteractions(method string, path string) []RequestResponse {
@@ -124,21 +541,583 @@ func (m *Interactions) AllInteractions(method string, path string) []RequestResp
 	if !ok {
 		return []RequestResponse{}
 	}
-	return mi.requestResponses
+	requestResponses := make([]RequestResponse, 0, len(mi.requestResponses))
+	for _, rr := range mi.requestResponses {
+		requestResponses = append(requestResponses, *rr)
+	}
+	return requestResponses
+}
+
+// RequestRate returns the number of requests per second received for the method and path over the last second.
+func (m *Interactions) RequestRate(method string, path string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mi, ok := m.interactions[getKey(method, path)]
+	if !ok {
+		return 0
+	}
+	return mi.rate(time.Now())
+}
+
+// CallCount returns how many requests for the method and path were answered by one of its interactions.
+// Unknown method and path combinations return 0.
+func (m *Interactions) CallCount(method string, path string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mi, ok := m.interactions[getKey(method, path)]
+	if !ok {
+		return 0
+	}
+	return mi.attempt
+}
+
+// Remaining returns how many more requests for the method and path the registered interactions will answer,
+// e.g. 1 after the first of two interactions was used. Persistent interactions are not counted.
+// Unknown method and path combinations return 0.
+func (m *Interactions) Remaining(method string, path string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mi, ok := m.interactions[getKey(method, path)]
+	if !ok {
+		return 0
+	}
+	remaining := 0
+	for _, rr := range mi.requestResponses {
+		if !rr.consumed && !rr.Persistent && rr.Times > rr.calls {
+			remaining += rr.Times - rr.calls
+		}
+	}
+	return remaining
+}
+
+// Unconsumed returns copies of the interactions of every method and path that were not used up by requests yet,
+// ordered by key and registration order. Persistent interactions are never reported.
+func (m *Interactions) Unconsumed() []RequestResponse {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var unconsumed []RequestResponse
+	for _, key := range m.sortedKeys() {
+		for _, rr := range m.interactions[key].requestResponses {
+			if !rr.consumed && !rr.Persistent {
+				unconsumed = append(unconsumed, *rr)
+			}
+		}
+	}
+	return unconsumed
+}
+
+// Find returns copies of the interactions of every method and path for which pred returns true, ordered by key and
+// registration order. pred receives copies taken under the lock, so it may call other methods of m.
+func (m *Interactions) Find(pred func(RequestResponse) bool) []RequestResponse {
+	m.lock.Lock()
+	var all []RequestResponse
+	for _, key := range m.sortedKeys() {
+		for _, rr := range m.interactions[key].requestResponses {
+			all = append(all, *rr)
+		}
+	}
+	m.lock.Unlock()
+
+	var found []RequestResponse
+	for _, rr := range all {
+		if pred(rr) {
+			found = append(found, rr)
+		}
+	}
+	return found
+}
+
+// AssertAllConsumed reports an error to t for every interaction that was not used up and returns whether all were.
+func (m *Interactions) AssertAllConsumed(t TestingT) bool {
+	unconsumed := m.Unconsumed()
+	for _, rr := range unconsumed {
+		t.Errorf("interaction %s %s responding %d was not consumed", rr.Method, rr.Path, rr.ResponseHttpStatus)
+	}
+	return len(unconsumed) == 0
+}
+
+// VerifyExpectations compares the number of consumed interactions of every method and path registered with
+// option.WithExpectedCalls against the expectation and returns an error for each mismatch.
+func (m *Interactions) VerifyExpectations() []error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var errs []error
+	for _, key := range m.sortedKeys() {
+		mi := m.interactions[key]
+		if mi.expectedCalls != nil && *mi.expectedCalls != mi.attempt {
+			errs = append(errs, fmt.Errorf("expected %d calls for key %s but got %d", *mi.expectedCalls, key, mi.attempt))
+		}
+	}
+	return errs
+}
+
+// countRateLimitedCall counts a call for the method and path against its rate limit and returns the number of calls so far.
+func (m *Interactions) countRateLimitedCall(method string, path string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mi, ok := m.interactions[getKey(method, path)]
+	if !ok {
+		return 0
+	}
+	mi.rateLimitCalls++
+	return mi.rateLimitCalls
+}
+
+// inFlight returns the semaphore limiting concurrent requests for the method and path, or nil if there is no limit.
+func (m *Interactions) inFlight(method string, path string) chan struct{} {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mi, ok := m.interactions[getKey(method, path)]
+	if !ok {
+		return nil
+	}
+	return mi.inFlight
 }
 
 func (m *Interactions) Reset() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	m.interactions = make(map[string]*interactions)
+	m.patternKeys = nil
+	m.scenarios = make(map[string]string)
+}
+
+// CapturedRequests returns a copy of the requests captured by the interactions for the method and path,
+// which is safe to use while the server keeps handling requests.
+func (m *Interactions) CapturedRequests(method string, path string) []CapturedRequest {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mi, ok := m.interactions[getKey(method, path)]
+	if !ok {
+		return []CapturedRequest{}
+	}
+	if mi.captureLimit > 0 {
+		captured := make([]CapturedRequest, 0, len(mi.history))
+		for i := range mi.history {
+			captured = append(captured, copyCaptured(mi.history[(mi.historyNext+i)%len(mi.history)]))
+		}
+		return captured
+	}
+	captured := make([]CapturedRequest, 0, len(mi.requestResponses))
+	for _, rr := range mi.requestResponses {
+		if rr.captured {
+			captured = append(captured, copyCaptured(newCapturedRequest(rr)))
+		}
+	}
+	return captured
+}
+
+func newCapturedRequest(rr *RequestResponse) CapturedRequest {
+	return CapturedRequest{
+		Method:  rr.Method,
+		Path:    rr.Path,
+		Body:    rr.CapturedRequestBody,
+		Headers: rr.CapturedRequestHeaders,
+		Query:   rr.CapturedQuery,
+		Matched: true,
+	}
+}
+
+func copyCaptured(captured CapturedRequest) CapturedRequest {
+	captured.Body = append([]byte(nil), captured.Body...)
+	captured.Headers = captured.Headers.Clone()
+	captured.Query = cloneValues(captured.Query)
+	return captured
+}
+
+// keepCapture adds the capture of rr to the ring buffer of the last captures of the key and drops the captured
+// request of the interactions whose capture fell out of it, so that memory stays bounded by the capture limit.
+func (mi *interactions) keepCapture(rr *RequestResponse) {
+	if len(mi.history) < mi.captureLimit {
+		mi.history = append(mi.history, newCapturedRequest(rr))
+	} else {
+		mi.history[mi.historyNext] = newCapturedRequest(rr)
+		mi.historyNext = (mi.historyNext + 1) % mi.captureLimit
+	}
+
+	for i, captured := range mi.capturedOrder {
+		if captured == rr {
+			mi.capturedOrder = append(mi.capturedOrder[:i:i], mi.capturedOrder[i+1:]...)
+			break
+		}
+	}
+	mi.capturedOrder = append(mi.capturedOrder, rr)
+	for len(mi.capturedOrder) > mi.captureLimit {
+		mi.capturedOrder[0].dropCaptured()
+		mi.capturedOrder = mi.capturedOrder[1:]
+	}
+}
+
+// WaitForCall blocks until a request for the method and path has been captured and returns a copy of the
+// interaction that captured the latest one. It returns at once if the path was already called.
+func (m *Interactions) WaitForCall(method string, path string, timeout time.Duration) (*RequestResponse, error) {
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		timedOut = true
+		m.called.Broadcast()
+	})
+	defer timer.Stop()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for {
+		if mi, ok := m.interactions[getKey(method, path)]; ok && mi.lastCaptured != nil {
+			captured := *mi.lastCaptured
+			return &captured, nil
+		}
+		if timedOut {
+			return nil, fmt.Errorf("timed out after %v waiting for a call to %s %s", timeout, method, path)
+		}
+		m.called.Wait()
+	}
+}
+
+// capturedRequest is what the server captures of a request for an interaction.
+type capturedRequest struct {
+	body    []byte
+	rawBody []byte
+	headers http.Header
+	query   url.Values
+	url     string
+	form    *CapturedForm
+	at      time.Time
+	// pathParams are the path parameters of the request, which are passed to RequestCaptureFuncV2.
+	pathParams map[string]string
+}
+
+// capture stores the captured request on the interaction under the lock and then runs its capture funcs,
+// returning an error if one of them panics.
+func (m *Interactions) capture(r *RequestResponse, req capturedRequest) error {
+	m.lock.Lock()
+	r.setCaptured(req.body, req.headers)
+	r.RawCapturedRequestBody = req.rawBody
+	r.CapturedQuery = req.query
+	r.CapturedURL = req.url
+	r.CapturedForm = req.form
+	r.CapturedAt = req.at
+	if mi, ok := m.interactions[getKey(r.Method, r.Path)]; ok {
+		mi.lastCaptured = r
+		if mi.captureLimit > 0 {
+			mi.keepCapture(r)
+		}
+	}
+	m.called.Broadcast()
+	m.lock.Unlock()
+
+	return r.recoverCaptureFuncs(req.body, req.headers, req.pathParams)
+}
+
+// recoverCaptureFuncs runs the capture funcs and turns a panic in one of them into an error.
+func (r *RequestResponse) recoverCaptureFuncs(requestBody []byte, headers http.Header, pathParams map[string]string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("request capture func for %s %s panicked: %v", r.Method, r.Path, p)
+		}
+	}()
+	r.runCaptureFuncs(requestBody, headers, pathParams)
+	return nil
+}
+
+// setElapsed stores how long the server took to handle the latest request of the interaction.
+func (m *Interactions) setElapsed(r *RequestResponse, elapsed time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	r.Elapsed = elapsed
+}
+
+// snapshot returns a copy of the interaction taken under the lock.
+func (m *Interactions) snapshot(r *RequestResponse) RequestResponse {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return *r
 }
 
 func (r *RequestResponse) Capture(requestBody []byte, headers http.Header) {
+	r.setCaptured(requestBody, headers)
+	r.runCaptureFuncs(requestBody, headers, r.PathParams)
+}
+
+func (r *RequestResponse) setCaptured(requestBody []byte, headers http.Header) {
 	r.CapturedRequestBody = requestBody
+	r.RawCapturedRequestBody = requestBody
 	r.CapturedRequestHeaders = headers
+	r.CapturedAt = time.Now()
+	r.captured = true
+}
+
+func (r *RequestResponse) dropCaptured() {
+	r.CapturedRequestBody = nil
+	r.RawCapturedRequestBody = nil
+	r.CapturedRequestHeaders = nil
+	r.CapturedQuery = nil
+	r.CapturedURL = ""
+	r.CapturedForm = nil
+	r.captured = false
+}
+
+func (r *RequestResponse) runCaptureFuncs(requestBody []byte, headers http.Header, pathParams map[string]string) {
 	if r.RequestCaptureFunc != nil {
 		r.RequestCaptureFunc(requestBody, headers)
 	}
+	if r.RequestCaptureFuncV2 != nil {
+		r.RequestCaptureFuncV2(requestBody, headers, pathParams)
+	}
+}
+
+// CapturedBodyAs unmarshals the captured JSON request body into v.
+func (r *RequestResponse) CapturedBodyAs(v interface{}) error {
+	if len(r.CapturedRequestBody) == 0 {
+		return fmt.Errorf("no request body captured for %s %s", r.Method, r.Path)
+	}
+	if err := jsoniter.Unmarshal(r.CapturedRequestBody, v); err != nil {
+		return fmt.Errorf("invalid JSON request body captured for %s %s: %w", r.Method, r.Path, err)
+	}
+	return nil
+}
+
+func (r *RequestResponse) hasMatchers() bool {
+	return r.RequestBody != nil || r.BodyMatcher != nil || len(r.QueryParams) > 0 || len(r.RequestHeaders) > 0
+}
+
+// needsBody reports whether the interaction can only be matched against the request body.
+func (r *RequestResponse) needsBody() bool {
+	return r.RequestBody != nil || r.BodyMatcher != nil
+}
+
+func (r *RequestResponse) matches(req *http.Request, body []byte) bool {
+	if r.RequestBody != nil && !bytes.Equal(r.RequestBody, body) {
+		return false
+	}
+	if r.BodyMatcher != nil && !r.BodyMatcher(body) {
+		return false
+	}
+	if len(r.QueryParams) > 0 {
+		query := req.URL.Query()
+		for name, value := range r.QueryParams {
+			if values, ok := query[name]; !ok || !contains(values, value) {
+				return false
+			}
+		}
+	}
+	for name, values := range r.RequestHeaders {
+		actual := req.Header.Values(name)
+		for _, value := range values {
+			if !contains(actual, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFor returns the index of the first unconsumed interaction whose matchers accept the request,
+// falling back to the first unconsumed interaction without matchers, or -1.
+// Persistent interactions are only considered once no one-shot interaction is left.
+func (mi *interactions) selectFor(r *http.Request, body []byte, eligible func(*RequestResponse) bool) int {
+	for _, persistent := range []bool{false, true} {
+		index := mi.find(func(rr *RequestResponse) bool {
+			return rr.Persistent == persistent && eligible(rr) && rr.hasMatchers() && rr.matches(r, body)
+		})
+		if index < 0 {
+			index = mi.find(func(rr *RequestResponse) bool {
+				return rr.Persistent == persistent && eligible(rr) && !rr.hasMatchers()
+			})
+		}
+		if index >= 0 {
+			return index
+		}
+	}
+	return -1
+}
+
+// consume counts a call of the interaction at index, marks it as used once it was returned Times times and returns
+// the stored interaction itself, so that what the handler captures is visible through Interaction and AllInteractions.
+func (mi *interactions) consume(index int) *RequestResponse {
+	requestResponse := mi.requestResponses[index]
+	requestResponse.calls++
+	requestResponse.consumed = !requestResponse.Persistent && requestResponse.calls >= requestResponse.Times
+	mi.attempt++
+	return requestResponse
+}
+
+// consume consumes the interaction at index and moves its scenario to the interaction's new state.
+func (m *Interactions) consume(mi *interactions, index int) *RequestResponse {
+	requestResponse := mi.consume(index)
+	if requestResponse.Scenario != "" && requestResponse.NewState != "" {
+		m.logger.Info("scenario changed state", zap.String("scenario", requestResponse.Scenario), zap.String("state", requestResponse.NewState))
+		m.scenarios[requestResponse.Scenario] = requestResponse.NewState
+	}
+	return requestResponse
+}
+
+// inState reports whether the scenario of the interaction, if any, is in the state the interaction requires.
+func (m *Interactions) inState(rr *RequestResponse) bool {
+	return rr.Scenario == "" || m.scenarioState(rr.Scenario) == rr.RequiredState
+}
+
+// ScenarioState returns the current state of the named scenario.
+func (m *Interactions) ScenarioState(name string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.scenarioState(name)
+}
+
+func (m *Interactions) scenarioState(name string) string {
+	if state, ok := m.scenarios[name]; ok {
+		return state
+	}
+	return option.ScenarioStarted
+}
+
+func (mi *interactions) find(pred func(*RequestResponse) bool) int {
+	for i := range mi.requestResponses {
+		if rr := mi.requestResponses[i]; !rr.consumed && pred(rr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Interactions) sortedKeys() []string {
+	keys := make([]string, 0, len(m.interactions))
+	for key := range m.interactions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (mi *interactions) recordArrival(now time.Time) {
+	mi.pruneArrivals(now)
+	mi.arrivals = append(mi.arrivals, now)
+}
+
+func (mi *interactions) rate(now time.Time) float64 {
+	mi.pruneArrivals(now)
+	return float64(len(mi.arrivals)) / rateWindow.Seconds()
+}
+
+// admit reports why the interaction must not answer the current request, if it must not.
+func (mi *interactions) admit(rr *RequestResponse) rejection {
+	if rr.DegradeAboveRate > 0 && mi.rate(time.Now()) > rr.DegradeAboveRate {
+		return rejectedDegraded
+	}
+	if rr.ConcurrencyLimit > 0 && rr.Overflow == option.OverflowReject && mi.inFlight != nil {
+		select {
+		case mi.inFlight <- struct{}{}:
+		default:
+			return rejectedConcurrency
+		}
+	}
+	return notRejected
+}
+
+func (mi *interactions) pruneArrivals(now time.Time) {
+	cutoff := now.Add(-rateWindow)
+	i := 0
+	for i < len(mi.arrivals) && !mi.arrivals[i].After(cutoff) {
+		i++
+	}
+	mi.arrivals = mi.arrivals[i:]
+}
+
+// isPathTemplate reports whether path has gin style parameter segments like /users/:id or /files/*path.
+func isPathTemplate(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+			return true
+		}
+	}
+	return false
+}
+
+// pathTemplateRegex converts a path template into a regular expression with a named group per parameter.
+// ":name" matches up to the end of the segment and "*name" up to the end of the path. A name consists of letters,
+// digits and underscores, so a segment can combine parameters and literals like ":name.json" or ":major-:minor".
+func pathTemplateRegex(path string) (*regexp.Regexp, error) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+			segments[i] = templateSegmentRegex(segment)
+		} else {
+			segments[i] = regexp.QuoteMeta(segment)
+		}
+	}
+	re, err := regexp.Compile("^" + strings.Join(segments, "/") + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %q: %w", path, err)
+	}
+	return re, nil
+}
+
+var templateParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// templateSegmentRegex converts the parameters of a template segment into named groups and quotes everything else.
+func templateSegmentRegex(segment string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range templateParam.FindAllStringSubmatchIndex(segment, -1) {
+		b.WriteString(regexp.QuoteMeta(segment[last:loc[0]]))
+		group := "[^/]+"
+		if segment[loc[0]] == '*' {
+			group = ".*"
+		}
+		b.WriteString("(?P<" + segment[loc[2]:loc[3]] + ">" + group + ")")
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(segment[last:]))
+	return b.String()
+}
+
+// pathParams returns the named groups of re matched against path.
+func pathParams(re *regexp.Regexp, path string) map[string]string {
+	match := re.FindStringSubmatch(path)
+	params := make(map[string]string)
+	for i, name := range re.SubexpNames() {
+		if i > 0 && name != "" && i < len(match) {
+			params[name] = match[i]
+		}
+	}
+	return params
+}
+
+// delay returns the fixed response delay plus a random delay between RandomDelayMin and RandomDelayMax.
+func (r *RequestResponse) delay() time.Duration {
+	delay := r.DelayResponse
+	if r.RandomDelayMax > 0 {
+		delay += r.RandomDelayMin + time.Duration(rand.Int63n(int64(r.RandomDelayMax-r.RandomDelayMin)+1))
+	}
+	return delay
+}
+
+func cloneValues(values url.Values) url.Values {
+	if values == nil {
+		return nil
+	}
+	clone := make(url.Values, len(values))
+	for name, v := range values {
+		clone[name] = append([]string(nil), v...)
+	}
+	return clone
 }
 
 func getKey(method string, path string) string {
@@ -147,4 +1126,6 @@ func getKey(method string, path string) string {
 
 func addDelay(req *RequestResponse, options option.HttpMockOptions) {
 	req.DelayResponse = options.Delay
+	req.RandomDelayMin = options.RandomDelayMin
+	req.RandomDelayMax = options.RandomDelayMax
 }
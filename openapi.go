@@ -0,0 +1,219 @@
+package httpmock
+
+import (
+	"fmt"
+	"github.com/httpmock/option"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+var openAPIMethods = map[string]string{
+	"get":     http.MethodGet,
+	"put":     http.MethodPut,
+	"post":    http.MethodPost,
+	"delete":  http.MethodDelete,
+	"options": http.MethodOptions,
+	"head":    http.MethodHead,
+	"patch":   http.MethodPatch,
+	"trace":   http.MethodTrace,
+}
+
+var (
+	openAPIPathParam = regexp.MustCompile(`\{([^}/]+)\}`)
+	groupName        = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+type openAPIDocument struct {
+	OpenAPI    string                          `yaml:"openapi"`
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas map[string]interface{} `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `yaml:"responses"`
+}
+
+type openAPIResponse struct {
+	Content map[string]openAPIMediaType `yaml:"content"`
+}
+
+type openAPIMediaType struct {
+	Example  interface{}                            `yaml:"example"`
+	Examples map[string]struct{ Value interface{} } `yaml:"examples"`
+	Schema   interface{}                            `yaml:"schema"`
+}
+
+// LoadOpenAPI registers a persistent interaction for every operation of the OpenAPI 3 spec in the JSON or YAML file
+// at path. Each responds with the first 2xx response of the operation, using its example or an object built from the
+// examples and defaults of its schema as body. Path parameters like /pets/{id} or /files/{name}.json match any value
+// within their segment; the interactions are registered under the paths as written in the spec.
+func (s *Server) LoadOpenAPI(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read OpenAPI spec %s: %w", path, err)
+	}
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse OpenAPI spec %s: %w", path, err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return fmt.Errorf("unsupported OpenAPI version %q in %s", doc.OpenAPI, path)
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		names := make([]string, 0, len(doc.Paths[p]))
+		for name := range doc.Paths[p] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			method, ok := openAPIMethods[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+			node := doc.Paths[p][name]
+			var op openAPIOperation
+			if err := node.Decode(&op); err != nil {
+				return fmt.Errorf("failed to parse operation %s %s in %s: %w", method, p, path, err)
+			}
+			status, response, ok := successResponse(op)
+			if !ok {
+				s.logger.Warn("skipping OpenAPI operation without 2xx response", zap.String("method", method), zap.String("path", p))
+				continue
+			}
+			contentType, body := exampleBody(response, doc.Components.Schemas)
+			opts := []option.HttpMockOptionFunc{option.WithPersistent()}
+			if openAPIPathParam.MatchString(p) {
+				pattern, err := openAPIPathRegex(p)
+				if err != nil {
+					return fmt.Errorf("invalid path %s in %s: %w", p, path, err)
+				}
+				opts = append(opts, option.WithPathRegex(pattern))
+			}
+			s.AddInteraction(method, p, status, body, contentType, nil, opts...)
+		}
+	}
+	return nil
+}
+
+// openAPIPathRegex converts a spec path into a regular expression matching any value for its {param} placeholders.
+// Parameters whose names are valid group names are captured as path parameters.
+func openAPIPathRegex(p string) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, loc := range openAPIPathParam.FindAllStringSubmatchIndex(p, -1) {
+		b.WriteString(regexp.QuoteMeta(p[last:loc[0]]))
+		if name := p[loc[2]:loc[3]]; groupName.MatchString(name) {
+			b.WriteString("(?P<" + name + ">[^/]+)")
+		} else {
+			b.WriteString("[^/]+")
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(p[last:]))
+	pattern := "^" + b.String() + "$"
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", err
+	}
+	return pattern, nil
+}
+
+// successResponse returns the first 2xx response of the operation with its status code.
+func successResponse(op openAPIOperation) (int, openAPIResponse, bool) {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return 0, openAPIResponse{}, false
+	}
+	sort.Strings(codes)
+	status, err := strconv.Atoi(codes[0])
+	if err != nil {
+		status = http.StatusOK
+	}
+	return status, op.Responses[codes[0]], true
+}
+
+// exampleBody picks the JSON content of the response, or else the first one, and returns its content type and example.
+func exampleBody(response openAPIResponse, schemas map[string]interface{}) (string, interface{}) {
+	if len(response.Content) == 0 {
+		return "JSON", nil
+	}
+	contentTypes := make([]string, 0, len(response.Content))
+	for ct := range response.Content {
+		contentTypes = append(contentTypes, ct)
+	}
+	sort.Strings(contentTypes)
+	contentType := contentTypes[0]
+	for _, ct := range contentTypes {
+		if isJSON(ct) {
+			contentType = ct
+			break
+		}
+	}
+
+	media := response.Content[contentType]
+	if media.Example != nil {
+		return contentType, media.Example
+	}
+	if len(media.Examples) > 0 {
+		names := make([]string, 0, len(media.Examples))
+		for name := range media.Examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return contentType, media.Examples[names[0]].Value
+	}
+	return contentType, schemaExample(media.Schema, schemas, 0)
+}
+
+// schemaExample builds an example value from the example, default and properties of the schema.
+func schemaExample(schema interface{}, schemas map[string]interface{}, depth int) interface{} {
+	s, ok := schema.(map[string]interface{})
+	if !ok || depth > 10 {
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		return schemaExample(schemas[strings.TrimPrefix(ref, "#/components/schemas/")], schemas, depth+1)
+	}
+	if example, ok := s["example"]; ok {
+		return example
+	}
+	if def, ok := s["default"]; ok {
+		return def
+	}
+	switch s["type"] {
+	case "object":
+		properties, _ := s["properties"].(map[string]interface{})
+		obj := make(map[string]interface{}, len(properties))
+		for name, property := range properties {
+			if value := schemaExample(property, schemas, depth+1); value != nil {
+				obj[name] = value
+			}
+		}
+		return obj
+	case "array":
+		if item := schemaExample(s["items"], schemas, depth+1); item != nil {
+			return []interface{}{item}
+		}
+		return []interface{}{}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package option
+
+import "go.uber.org/zap"
+
+type NotifyOptionFunc func(*NotifyOptions) error
+
+type NotifyOptions struct {
+	Method           string
+	Path             string
+	Blocking         bool
+	IncludeUnmatched bool
+}
+
+// ForRequest only notifies about requests with the given method and path. Empty values match anything.
+func ForRequest(method string, path string) NotifyOptionFunc {
+	return func(o *NotifyOptions) error {
+		o.Method = method
+		o.Path = path
+		return nil
+	}
+}
+
+// WithBlockingNotify makes the handler wait until the channel accepts the request instead of dropping it when the channel is full.
+// The wait is abandoned when the client cancels the request.
+func WithBlockingNotify() NotifyOptionFunc {
+	return func(o *NotifyOptions) error {
+		o.Blocking = true
+		return nil
+	}
+}
+
+// WithUnmatchedNotify also notifies about requests for which no interaction was found.
+func WithUnmatchedNotify() NotifyOptionFunc {
+	return func(o *NotifyOptions) error {
+		o.IncludeUnmatched = true
+		return nil
+	}
+}
+
+func ProcessNotifyOptions(logger *zap.Logger, optionFunc []NotifyOptionFunc) NotifyOptions {
+
+	var op NotifyOptions
+
+	for _, fn := range optionFunc {
+		if err := fn(&op); err != nil {
+			logger.Panic("load notify option failed", zap.Error(err))
+		}
+	}
+
+	return op
+}
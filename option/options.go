@@ -1,6 +1,15 @@
 package option
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
 	"time"
 
 	"go.uber.org/zap"
@@ -9,16 +18,552 @@ import (
 type HttpMockOptionFunc func(*HttpMockOptions) error
 
 type HttpMockOptions struct {
+	Delay            time.Duration
+	RequestBody      []byte
+	CloseDelimited   bool
+	ReasonPhrase     string
+	ServerTiming     map[string]time.Duration
+	DegradeAboveRate float64
+	ExpectedCalls    *int
+	ChecksumField    string
+	ChecksumAlgo     string
+	ExpectContinue   ExpectContinueBehavior
+	RangeRequests    bool
+	ConcurrencyLimit int
+	Overflow         ConcurrencyOverflow
+	QueryParams      map[string]string
+	RequestHeaders   http.Header
+	BodyMatcher      func(body []byte) bool
+	PathRegex        *regexp.Regexp
+	CaptureFuncV2    func(body []byte, headers http.Header, pathParams map[string]string)
+	RawResponseBody  []byte
+	ResponseBodyFile string
+	ResponseReader   func() io.Reader
+	Priority         int
+	FormResponse     url.Values
+	ResponseFunc     func(req *http.Request, body []byte) (status int, obj interface{}, headers http.Header)
+	Times            int
+	Persistent       bool
+	ResponseHeaders  http.Header
+	ResponseCookies  []*http.Cookie
+	RedirectLocation string
+	RandomDelayMin   time.Duration
+	RandomDelayMax   time.Duration
+	Fault            FaultType
+	RateLimited      bool
+	RateLimit        int
+	RetryAfter       time.Duration
+	Scenario         string
+	RequiredState    string
+	NewState         string
+	// AttemptResponses holds the responses set by WithResponseForAttempt, keyed by attempt.
+	AttemptResponses  map[int]AttemptResponse
+	RepeatLastAttempt bool
+	Hang              bool
+	SSEEvents         []SSEEvent
+	StreamedChunks    [][]byte
+	StreamInterval    time.Duration
+	GzipResponse      bool
+	ResponseTemplate  *template.Template
+	Representations   map[string]interface{}
+	RequestSchema     []byte
+	CaptureLimit      int
+}
+
+// SSEEvent is a server-sent event, see WithSSEEvents. Delay is waited before the event is sent.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
 	Delay time.Duration
 }
 
+// AttemptResponse is the response of a single attempt, see WithResponseForAttempt.
+type AttemptResponse struct {
+	Status int
+	Object interface{}
+}
+
+// FaultType is a network level failure injected instead of a response.
+type FaultType int
+
+const (
+	FaultNone FaultType = iota
+	// FaultConnectionReset resets the TCP connection without sending a response.
+	FaultConnectionReset
+	// FaultEmptyResponse closes the connection without sending a response.
+	FaultEmptyResponse
+)
+
+// ConcurrencyOverflow controls what happens to requests above the concurrency limit of a method and path.
+type ConcurrencyOverflow int
+
+const (
+	// OverflowReject responds with 503 Service Unavailable without consuming the interaction. This is the default.
+	OverflowReject ConcurrencyOverflow = iota
+	// OverflowQueue waits until one of the in-flight requests completes or the client gives up.
+	OverflowQueue
+)
+
+// ExpectContinueBehavior controls how the server answers requests sent with "Expect: 100-continue".
+// net/http sends "100 Continue" on its own the first time the handler reads the request body, and the mock reads the
+// body of every request, so by default such clients always get the interim response.
+// If the handler answers without reading the body, no "100 Continue" is sent and net/http closes the connection
+// after the final response, since the unread body can't be drained.
+type ExpectContinueBehavior int
+
+const (
+	// ExpectContinueAccept sends "100 Continue", reads the body and responds normally. This is the default.
+	ExpectContinueAccept ExpectContinueBehavior = iota
+	// ExpectContinueReject responds with 417 Expectation Failed without reading the body.
+	ExpectContinueReject
+	// ExpectContinueFinalStatus responds with the interaction's status, without a body, before reading the request body.
+	ExpectContinueFinalStatus
+)
+
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+)
+
+// WithResponseDelay delays each response by delay, which must not be negative.
 func WithResponseDelay(delay time.Duration) HttpMockOptionFunc {
 	return func(o *HttpMockOptions) error {
+		if delay < 0 {
+			return fmt.Errorf("invalid negative response delay %v", delay)
+		}
 		o.Delay = delay
 		return nil
 	}
 }
 
+// WithRandomDelay delays each response by a random duration between min and max, in addition to WithResponseDelay.
+func WithRandomDelay(min time.Duration, max time.Duration) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if min < 0 || min > max {
+			return fmt.Errorf("invalid random delay range [%v, %v]", min, max)
+		}
+		o.RandomDelayMin = min
+		o.RandomDelayMax = max
+		return nil
+	}
+}
+
+// WithRequestBody makes the interaction match only requests whose raw body is exactly equal to body.
+// Interactions without a body requirement act as the default for the same method and path.
+func WithRequestBody(body []byte) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.RequestBody = body
+		return nil
+	}
+}
+
+// WithCloseDelimitedResponse writes the response HTTP/1.0 style, without Content-Length or chunked encoding,
+// and closes the connection to mark the end of the body.
+// The response writer must support hijacking (http.Hijacker), which rules out HTTP/2 connections.
+func WithCloseDelimitedResponse() HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.CloseDelimited = true
+		return nil
+	}
+}
+
+// WithReasonPhrase responds with phrase instead of the standard reason phrase in the status line, e.g. for a
+// nonstandard status code like 599. net/http cannot write custom reason phrases, so the response is written
+// close delimited as with WithCloseDelimitedResponse.
+func WithReasonPhrase(phrase string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ReasonPhrase = phrase
+		return nil
+	}
+}
+
+// WithServerTiming adds a Server-Timing header to the response with one metric per entry, durations in milliseconds.
+func WithServerTiming(metrics map[string]time.Duration) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ServerTiming = metrics
+		return nil
+	}
+}
+
+// WithDegradeAboveRate responds with 503 instead of the configured response while the arrival rate of requests
+// for the method and path, measured over the last second, exceeds ratePerSec. Degraded requests do not consume
+// the interaction.
+func WithDegradeAboveRate(ratePerSec float64) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.DegradeAboveRate = ratePerSec
+		return nil
+	}
+}
+
+// WithExpectedCalls declares that the method and path must be called exactly n times by the time the server shuts down.
+func WithExpectedCalls(n int) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ExpectedCalls = &n
+		return nil
+	}
+}
+
+// WithResponseChecksum adds the hex digest of the received request body to the response object under field.
+// algo is one of ChecksumMD5, ChecksumSHA1, ChecksumSHA256 or ChecksumSHA512.
+func WithResponseChecksum(field string, algo string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		algo = strings.ToLower(algo)
+		switch algo {
+		case ChecksumMD5, ChecksumSHA1, ChecksumSHA256, ChecksumSHA512:
+		default:
+			return fmt.Errorf("unsupported checksum algorithm %q", algo)
+		}
+		if field == "" {
+			return errors.New("checksum field must not be empty")
+		}
+		o.ChecksumField = field
+		o.ChecksumAlgo = algo
+		return nil
+	}
+}
+
+// WithExpectContinue sets how the interaction reacts to requests sent with "Expect: 100-continue".
+func WithExpectContinue(behavior ExpectContinueBehavior) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ExpectContinue = behavior
+		return nil
+	}
+}
+
+// WithRangeRequests honors single byte ranges in the Range request header by responding with 206 Partial Content
+// and the requested slice of the serialized response body, or 416 Range Not Satisfiable.
+func WithRangeRequests() HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.RangeRequests = true
+		return nil
+	}
+}
+
+// WithKeyConcurrencyLimit allows at most n requests for the method and path to be handled at the same time.
+// Requests above the limit are handled according to overflow.
+func WithKeyConcurrencyLimit(n int, overflow ConcurrencyOverflow) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("concurrency limit must be positive, got %d", n)
+		}
+		o.ConcurrencyLimit = n
+		o.Overflow = overflow
+		return nil
+	}
+}
+
+// WithQueryParams makes the interaction match only requests whose query string has every given parameter with the given value.
+func WithQueryParams(params map[string]string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.QueryParams = params
+		return nil
+	}
+}
+
+// WithRequestHeaders makes the interaction match only requests carrying every given header value.
+// Header names are compared case-insensitively.
+func WithRequestHeaders(headers http.Header) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.RequestHeaders = headers
+		return nil
+	}
+}
+
+// WithBodyMatcher makes the interaction match only requests whose body satisfies matcher.
+func WithBodyMatcher(matcher func(body []byte) bool) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.BodyMatcher = matcher
+		return nil
+	}
+}
+
+// WithPathRegex makes the interaction answer requests whose path matches pattern when no interaction is registered
+// for the exact path, or only ones of lower priority. The path given when adding the interaction is only used as its key.
+func WithPathRegex(pattern string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid path regex %q: %w", pattern, err)
+		}
+		o.PathRegex = re
+		return nil
+	}
+}
+
+// WithCaptureFuncV2 calls fn with the captured request and the parameters extracted from a templated path
+// such as /users/:id, or from the named groups of a path regex.
+func WithCaptureFuncV2(fn func(body []byte, headers http.Header, pathParams map[string]string)) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.CaptureFuncV2 = fn
+		return nil
+	}
+}
+
+// WithRawResponseBody responds with body verbatim instead of serializing the response object.
+func WithRawResponseBody(body []byte) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.RawResponseBody = body
+		return nil
+	}
+}
+
+// WithResponseBodyFile responds with the content of the file at path, read on every request.
+// Range requests are honored as with WithRangeRequests.
+// The content type is taken from the interaction when it is a MIME type, otherwise it is guessed from the file extension.
+func WithResponseBodyFile(path string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ResponseBodyFile = path
+		return nil
+	}
+}
+
+// WithResponseFunc computes the response from the received request instead of using the registered status and object.
+// Returned headers are added to the response.
+func WithResponseFunc(fn func(req *http.Request, body []byte) (status int, obj interface{}, headers http.Header)) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ResponseFunc = fn
+		return nil
+	}
+}
+
+// WithTimes returns the interaction for n requests before moving on to the next one. Defaults to 1.
+func WithTimes(n int) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("times must be positive, got %d", n)
+		}
+		o.Times = n
+		return nil
+	}
+}
+
+// WithPersistent never consumes the interaction, so it answers every request once the one-shot interactions
+// for the same method and path are used up, e.g. "first two calls fail, then succeed forever".
+func WithPersistent() HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.Persistent = true
+		return nil
+	}
+}
+
+// WithResponseHeaders adds headers to the response.
+func WithResponseHeaders(headers http.Header) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ResponseHeaders = headers
+		return nil
+	}
+}
+
+// WithResponseCookies sets a Set-Cookie header for each cookie, including its attributes, on every response.
+func WithResponseCookies(cookies []*http.Cookie) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ResponseCookies = cookies
+		return nil
+	}
+}
+
+// WithRedirect responds with a Location header pointing to location and no body.
+// The interaction status should be a 3xx redirect status; any other status is replaced by 302 Found.
+func WithRedirect(location string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.RedirectLocation = location
+		return nil
+	}
+}
+
+// WithFault breaks the connection as described by fault instead of responding, to exercise client error paths
+// that a 5xx response can't reach.
+func WithFault(fault FaultType) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.Fault = fault
+		return nil
+	}
+}
+
+// WithRateLimit answers the first n calls for the method and path normally and every further call with
+// 429 Too Many Requests and a Retry-After header. The interaction is persistent, so it keeps answering after n calls.
+func WithRateLimit(n int, retryAfter time.Duration) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if n < 0 || retryAfter < 0 {
+			return fmt.Errorf("invalid rate limit of %d calls with retry after %v", n, retryAfter)
+		}
+		o.RateLimited = true
+		o.RateLimit = n
+		o.RetryAfter = retryAfter
+		o.Persistent = true
+		return nil
+	}
+}
+
+// WithHang accepts the request and never responds, to exercise client timeouts. The handler returns once the client
+// gives up on the request, and shutting down the server closes the connection.
+func WithHang() HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.Hang = true
+		return nil
+	}
+}
+
+// WithSSEEvents streams the events as a text/event-stream response, flushing each one after its delay.
+// The response ends after the last event or when the client disconnects.
+func WithSSEEvents(events []SSEEvent) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.SSEEvents = events
+		return nil
+	}
+}
+
+// WithStreamedBody writes the chunks as a chunked response body, flushing each one and waiting interval between them.
+func WithStreamedBody(chunks [][]byte, interval time.Duration) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if interval < 0 {
+			return fmt.Errorf("invalid stream interval %v", interval)
+		}
+		o.StreamedChunks = chunks
+		o.StreamInterval = interval
+		return nil
+	}
+}
+
+// WithGzipResponse compresses the response body with gzip and sets Content-Encoding when the request accepts gzip.
+func WithGzipResponse() HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.GzipResponse = true
+		return nil
+	}
+}
+
+// WithResponseTemplate renders the response body from a text/template for each request. The template can use
+// .Method, .Path, .Body (the JSON request body as a map, nil for other bodies), .Query, .Headers and .PathParams.
+func WithResponseTemplate(tmpl string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		t, err := template.New("response").Parse(tmpl)
+		if err != nil {
+			return fmt.Errorf("invalid response template: %w", err)
+		}
+		o.ResponseTemplate = t
+		return nil
+	}
+}
+
+// WithRepresentations responds with the object of the content type that best matches the Accept header of the
+// request, instead of the registered object. Content types are MIME types or the legacy "JSON" and "XML";
+// requests accepting none of them get the JSON representation.
+func WithRepresentations(representations map[string]interface{}) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if len(representations) == 0 {
+			return errors.New("representations must not be empty")
+		}
+		o.Representations = representations
+		return nil
+	}
+}
+
+// WithRequestSchema validates the body of every request for the method and path against the JSON schema before
+// an interaction is matched, and answers invalid bodies with 400 Bad Request listing the violations.
+func WithRequestSchema(schema []byte) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if !json.Valid(schema) {
+			return errors.New("request schema is not valid JSON")
+		}
+		o.RequestSchema = schema
+		return nil
+	}
+}
+
+// WithCaptureLimit keeps only the last n requests captured for the method and path, in a ring buffer returned by
+// Interactions.CapturedRequests. Interactions whose capture was dropped no longer hold the captured request.
+func WithCaptureLimit(n int) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid capture limit %d", n)
+		}
+		o.CaptureLimit = n
+		return nil
+	}
+}
+
+// WithResponseForAttempt responds to the given attempt, starting at 0 for the first call, with status and obj instead
+// of the registered response. The interaction is used until its last attempt has been answered, after which
+// requests fall through to the next interaction or 501 unless WithRepeatLastAttempt is set.
+func WithResponseForAttempt(attempt int, status int, obj interface{}) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if attempt < 0 {
+			return fmt.Errorf("invalid attempt %d", attempt)
+		}
+		if o.AttemptResponses == nil {
+			o.AttemptResponses = make(map[int]AttemptResponse)
+		}
+		o.AttemptResponses[attempt] = AttemptResponse{Status: status, Object: obj}
+		return nil
+	}
+}
+
+// WithRepeatLastAttempt keeps answering with the response of the last attempt set by WithResponseForAttempt once
+// all attempts have been answered.
+func WithRepeatLastAttempt() HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.RepeatLastAttempt = true
+		return nil
+	}
+}
+
+// ScenarioStarted is the state every scenario is in until an interaction moves it to a new state.
+const ScenarioStarted = "Started"
+
+// WithScenario only matches the interaction while the named scenario is in requiredState.
+// Scenarios start in ScenarioStarted.
+func WithScenario(name string, requiredState string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		if name == "" {
+			return errors.New("scenario name must not be empty")
+		}
+		o.Scenario = name
+		o.RequiredState = requiredState
+		return nil
+	}
+}
+
+// WithNewState moves the scenario of the interaction to state once the interaction has been used.
+// It must be combined with WithScenario.
+func WithNewState(state string) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.NewState = state
+		return nil
+	}
+}
+
+// WithResponseReader streams the response body from the reader returned by factory, which is called on every request.
+// The Content-Length is set if the reader has a Len method like bytes.Reader, otherwise the body is sent chunked.
+// A reader that is also an io.Closer is closed once the body has been sent.
+func WithResponseReader(factory func() io.Reader) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.ResponseReader = factory
+		return nil
+	}
+}
+
+// WithPriority makes the interaction win over lower priority interactions matching the same request, including
+// interactions registered for a path regex. The default priority is 0; ties are resolved by the usual rules.
+func WithPriority(n int) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.Priority = n
+		return nil
+	}
+}
+
+// WithFormResponse responds with values encoded as application/x-www-form-urlencoded, e.g. for OAuth token endpoints,
+// in place of the response object and content type of the interaction.
+func WithFormResponse(values url.Values) HttpMockOptionFunc {
+	return func(o *HttpMockOptions) error {
+		o.FormResponse = values
+		return nil
+	}
+}
+
 func ProcessOptions(logger *zap.Logger, optionFunc []HttpMockOptionFunc) HttpMockOptions {
 
 	var op HttpMockOptions
@@ -28,6 +573,9 @@ func ProcessOptions(logger *zap.Logger, optionFunc []HttpMockOptionFunc) HttpMoc
 			logger.Panic("load option failed", zap.Error(err))
 		}
 	}
+	if op.NewState != "" && op.Scenario == "" {
+		logger.Panic("load option failed", zap.Error(errors.New("new state requires a scenario")))
+	}
 
 	return op
 }
@@ -0,0 +1,123 @@
+package httpmock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+// headers that describe the connection or the encoding of a single response and must not be recorded or copied.
+var hopHeaders = []string{"Connection", "Content-Length", "Content-Type", "Date", "Keep-Alive", "Transfer-Encoding"}
+
+// upstreamClient returns redirects of the upstream as they are, so that the client and the recording see them.
+var upstreamClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// WithUpstream forwards requests without a matching interaction to baseURL and returns the real response.
+// Every forwarded exchange is recorded as an already consumed interaction, so it can be exported with
+// Interactions.Export and replayed later with LoadFromFile without the upstream.
+func (s *Server) WithUpstream(baseURL string) *Server {
+	upstream, err := url.Parse(baseURL)
+	if err != nil {
+		s.logger.Panic("invalid upstream URL", zap.String("url", baseURL), zap.Error(err))
+	}
+	s.upstream = upstream
+	return s
+}
+
+func (s *Server) proxy(c *gin.Context, body []byte) {
+	target := *s.upstream
+	target.Path = singleJoiningSlash(s.upstream.Path, c.Request.URL.Path)
+	target.RawQuery = c.Request.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		s.logger.Error("failed to create upstream request", zap.Error(err))
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	req.Header = c.Request.Header.Clone()
+	req.Header.Del("Content-Encoding")
+	// the transport asks for and decompresses gzip itself only if the request does not set Accept-Encoding,
+	// so that the recorded body is never compressed
+	req.Header.Del("Accept-Encoding")
+
+	s.logger.Info("forwarding request to upstream", zap.String("url", target.String()))
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		s.logger.Error("upstream request failed", zap.Error(err))
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		s.logger.Error("failed to read upstream response", zap.Error(err))
+		c.Status(http.StatusBadGateway)
+		return
+	}
+
+	headers := resp.Header.Clone()
+	for _, name := range hopHeaders {
+		headers.Del(name)
+	}
+	contentType := resp.Header.Get("Content-Type")
+
+	recorded := RequestResponse{
+		Path:                   c.Request.URL.Path,
+		Method:                 c.Request.Method,
+		ResponseHttpStatus:     resp.StatusCode,
+		ResponseObject:         recordedObject(contentType, respBody),
+		ResponseContentType:    contentType,
+		ResponseHeaders:        headers,
+		CapturedRequestBody:    body,
+		RawCapturedRequestBody: body,
+		CapturedRequestHeaders: c.Request.Header.Clone(),
+		Times:                  1,
+	}
+	s.Interactions.addRecorded(recorded)
+
+	for name, values := range headers {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Data(resp.StatusCode, contentType, respBody)
+}
+
+// recordedObject keeps JSON bodies as decoded objects so that exported interactions stay readable,
+// and everything else as text.
+func recordedObject(contentType string, body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	if isJSON(contentType) {
+		var obj interface{}
+		if err := jsoniter.Unmarshal(body, &obj); err == nil {
+			return obj
+		}
+	}
+	return string(body)
+}
+
+func singleJoiningSlash(a string, b string) string {
+	switch {
+	case a == "" || a == "/":
+		return b
+	case a[len(a)-1] == '/' && b != "" && b[0] == '/':
+		return a + b[1:]
+	case a[len(a)-1] != '/' && (b == "" || b[0] != '/'):
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,87 @@
+package httpmock
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// writeRange responds with the part of the serialized response body requested by the Range header.
+// Headers that are not a single byte range are ignored and the full body is returned.
+func (s *Server) writeRange(c *gin.Context, mock *RequestResponse) {
+	body, contentType, err := s.renderBody(mock)
+	if err != nil {
+		s.logger.Error("failed to marshal response object", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Accept-Ranges", "bytes")
+
+	start, end, ok, err := parseRange(c.GetHeader("Range"), len(body))
+	if err != nil {
+		s.logger.Info("responding with 416 since range is not satisfiable", zap.String("range", c.GetHeader("Range")))
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if !ok {
+		c.Data(mock.ResponseHttpStatus, contentType, body)
+		return
+	}
+
+	s.logger.Info("responding with partial content", zap.Int("start", start), zap.Int("end", end))
+	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+	c.Data(http.StatusPartialContent, contentType, body[start:end+1])
+}
+
+// parseRange parses a single "bytes=start-end", "bytes=start-" or "bytes=-suffix" range against a body of size bytes
+// and returns the inclusive bounds. ok is false when the header should be ignored.
+func parseRange(header string, size int) (start int, end int, ok bool, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		suffix, convErr := strconv.Atoi(last)
+		if convErr != nil {
+			return 0, 0, false, nil
+		}
+		if suffix <= 0 || size == 0 {
+			return 0, 0, false, errUnsatisfiableRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true, nil
+	}
+
+	start, convErr := strconv.Atoi(first)
+	if convErr != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = size - 1
+	if last != "" {
+		if end, convErr = strconv.Atoi(last); convErr != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+	return start, end, true, nil
+}
@@ -0,0 +1,43 @@
+package httpmock
+
+import (
+	"sync"
+	"time"
+)
+
+// RecordedCall is a single request received by one of the servers sharing a Recorder.
+type RecordedCall struct {
+	ServerID string
+	Method   string
+	Path     string
+	Time     time.Time
+}
+
+// Recorder keeps an ordered log of the requests received by every server it is shared with.
+type Recorder struct {
+	calls []RecordedCall
+	lock  sync.Mutex
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) record(call RecordedCall) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+// Sequence returns the recorded calls of all servers in the order they were received.
+func (r *Recorder) Sequence() []RecordedCall {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]RecordedCall(nil), r.calls...)
+}
+
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = nil
+}
@@ -0,0 +1,224 @@
+package httpmock
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// renderBody serializes the response object the same way the handler does, using the server's JSON marshaller,
+// and returns it with its content type.
+func (s *Server) renderBody(mock *RequestResponse) ([]byte, string, error) {
+	return renderBody(mock, s.marshal)
+}
+
+// renderBody serializes the response object with marshal for JSON and returns it with its content type.
+// A nil response object renders as an empty body.
+func renderBody(mock *RequestResponse, marshal func(interface{}) ([]byte, error)) ([]byte, string, error) {
+	if mock.ResponseBodyFile != "" {
+		body, err := os.ReadFile(mock.ResponseBodyFile)
+		return body, fileContentType(mock), err
+	}
+	if mock.RawResponseBody != nil {
+		return mock.RawResponseBody, contentType(mock.ResponseContentType), nil
+	}
+	if mock.ResponseObject == nil {
+		return nil, "", nil
+	}
+
+	ct := contentType(mock.ResponseContentType)
+	switch {
+	case isJSON(ct):
+		body, err := marshal(mock.ResponseObject)
+		return body, ct, err
+	case isXML(ct):
+		body, err := xml.Marshal(mock.ResponseObject)
+		return body, ct, err
+	default:
+		return textBody(mock.ResponseObject), ct, nil
+	}
+}
+
+// bodyAllowed reports whether a response with status may have a body, which 1xx, 204 and 304 responses must not.
+func bodyAllowed(status int) bool {
+	informational := status >= http.StatusContinue && status < http.StatusOK
+	return !informational && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// hasBody reports whether the interaction defines a response body.
+func hasBody(mock *RequestResponse) bool {
+	return mock.ResponseObject != nil || mock.RawResponseBody != nil || mock.ResponseBodyFile != "" ||
+		mock.ResponseReader != nil || len(mock.SSEEvents) > 0 || mock.StreamedChunks != nil
+}
+
+// WithMarshaller serializes JSON response objects with marshal instead of jsoniter's encoding/json compatible
+// configuration, e.g. jsoniter.Marshal to skip sorting map keys and escaping HTML.
+func (s *Server) WithMarshaller(marshal func(interface{}) ([]byte, error)) *Server {
+	s.marshaller = marshal
+	return s
+}
+
+func (s *Server) marshal(v interface{}) ([]byte, error) {
+	if s.marshaller != nil {
+		return s.marshaller(v)
+	}
+	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v)
+}
+
+// templateData is what a response template can refer to.
+type templateData struct {
+	Method     string
+	Path       string
+	Body       map[string]interface{}
+	Query      url.Values
+	Headers    http.Header
+	PathParams map[string]string
+}
+
+// renderTemplate executes the response template of the interaction for the request and its path parameters.
+func renderTemplate(mock *RequestResponse, pathParams map[string]string, r *http.Request, body []byte) ([]byte, error) {
+	data := templateData{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Headers:    r.Header,
+		PathParams: pathParams,
+	}
+	if len(body) > 0 {
+		_ = jsoniter.Unmarshal(body, &data.Body)
+	}
+	var rendered bytes.Buffer
+	if err := mock.ResponseTemplate.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+	return rendered.Bytes(), nil
+}
+
+// negotiate picks the representation whose content type best matches the Accept header, falling back to the JSON one.
+func negotiate(accept string, representations map[string]interface{}) (string, interface{}) {
+	contentTypes := make([]string, 0, len(representations))
+	for ct := range representations {
+		contentTypes = append(contentTypes, ct)
+	}
+	sort.Strings(contentTypes)
+
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		specificity := mediaRangeSpecificity(mediaRange)
+		for _, ct := range contentTypes {
+			if q > 0 && mediaRangeMatches(mediaRange, parseMediaType(contentType(ct))) &&
+				(q > bestQ || q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = ct, q, specificity
+			}
+		}
+	}
+	if best == "" || bestSpecificity == 0 {
+		for _, ct := range contentTypes {
+			if isJSON(contentType(ct)) {
+				return ct, representations[ct]
+			}
+		}
+		if best == "" {
+			best = contentTypes[0]
+		}
+	}
+	return best, representations[best]
+}
+
+// mediaRangeSpecificity is 0 for */*, 1 for type/* and 2 for a full media type.
+func mediaRangeSpecificity(mediaRange string) int {
+	switch {
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func mediaRangeMatches(mediaRange string, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+}
+
+// textBody renders the response object of a content type that is neither JSON nor XML.
+func textBody(obj interface{}) []byte {
+	switch v := obj.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case url.Values:
+		return []byte(v.Encode())
+	case fmt.Stringer:
+		return []byte(v.String())
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+// fileContentType returns the content type of the interaction if it is a MIME type, otherwise it is guessed from the
+// extension of the response body file.
+func fileContentType(mock *RequestResponse) string {
+	if strings.Contains(mock.ResponseContentType, "/") {
+		return mock.ResponseContentType
+	}
+	if guessed := mime.TypeByExtension(filepath.Ext(mock.ResponseBodyFile)); guessed != "" {
+		return guessed
+	}
+	return contentType(mock.ResponseContentType)
+}
+
+// contentType maps the legacy "JSON" and "XML" content types, in any case, to MIME types and returns anything else
+// as is, so any MIME type like "text/plain" or "application/yaml" can be used.
+func contentType(responseContentType string) string {
+	switch strings.ToUpper(strings.TrimSpace(responseContentType)) {
+	case "JSON", "":
+		return "application/json; charset=utf-8"
+	case "XML":
+		return "application/xml; charset=utf-8"
+	default:
+		return responseContentType
+	}
+}
+
+func isJSON(contentType string) bool {
+	mediaType := parseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func isXML(contentType string) bool {
+	mediaType := parseMediaType(contentType)
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+func parseMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(contentType)
+	}
+	return mediaType
+}
@@ -0,0 +1,213 @@
+package httpmock
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// validateSchema validates the JSON document against the JSON schema and returns the violations found.
+// It supports the commonly used keywords type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// allOf, anyOf and oneOf.
+func validateSchema(schema interface{}, document []byte) []string {
+	var value interface{}
+	if err := jsoniter.Unmarshal(document, &value); err != nil {
+		return []string{"body is not valid JSON: " + err.Error()}
+	}
+	return validateValue(schema, value, "$")
+}
+
+func validateValue(schema interface{}, value interface{}, path string) []string {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		if allowed, isBool := schema.(bool); isBool && !allowed {
+			return []string{path + ": no value is allowed"}
+		}
+		return nil
+	}
+
+	var errs []string
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		return []string{fmt.Sprintf("%s: expected type %v but got %s", path, t, jsonType(value))}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		errs = append(errs, fmt.Sprintf("%s: value %v is not one of %v", path, value, enum))
+	}
+	if c, ok := s["const"]; ok && !equalValues(c, value) {
+		errs = append(errs, fmt.Sprintf("%s: value %v is not %v", path, value, c))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, validateObject(s, v, path)...)
+	case []interface{}:
+		errs = append(errs, validateArray(s, v, path)...)
+	case string:
+		errs = append(errs, validateString(s, v, path)...)
+	case float64:
+		errs = append(errs, validateNumber(s, v, path)...)
+	}
+
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			errs = append(errs, validateValue(sub, value, path)...)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok && countValid(anyOf, value, path) == 0 {
+		errs = append(errs, path+": value does not match any schema of anyOf")
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok && countValid(oneOf, value, path) != 1 {
+		errs = append(errs, path+": value does not match exactly one schema of oneOf")
+	}
+	return errs
+}
+
+func validateObject(s map[string]interface{}, obj map[string]interface{}, path string) []string {
+	var errs []string
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, present := obj[fmt.Sprint(name)]; !present {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if sub, ok := properties[name]; ok {
+			errs = append(errs, validateValue(sub, obj[name], path+"."+name)...)
+		} else if additional, ok := s["additionalProperties"]; ok {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				errs = append(errs, fmt.Sprintf("%s: property %q is not allowed", path, name))
+			} else {
+				errs = append(errs, validateValue(additional, obj[name], path+"."+name)...)
+			}
+		}
+	}
+	return errs
+}
+
+func validateArray(s map[string]interface{}, arr []interface{}, path string) []string {
+	var errs []string
+	if min, ok := s["minItems"].(float64); ok && float64(len(arr)) < min {
+		errs = append(errs, fmt.Sprintf("%s: expected at least %v items but got %d", path, min, len(arr)))
+	}
+	if max, ok := s["maxItems"].(float64); ok && float64(len(arr)) > max {
+		errs = append(errs, fmt.Sprintf("%s: expected at most %v items but got %d", path, max, len(arr)))
+	}
+	if items, ok := s["items"]; ok {
+		for i, item := range arr {
+			errs = append(errs, validateValue(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+func validateString(s map[string]interface{}, str string, path string) []string {
+	var errs []string
+	length := float64(len([]rune(str)))
+	if min, ok := s["minLength"].(float64); ok && length < min {
+		errs = append(errs, fmt.Sprintf("%s: expected at least %v characters but got %v", path, min, length))
+	}
+	if max, ok := s["maxLength"].(float64); ok && length > max {
+		errs = append(errs, fmt.Sprintf("%s: expected at most %v characters but got %v", path, max, length))
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		if re, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid pattern %q in schema", path, pattern))
+		} else if !re.MatchString(str) {
+			errs = append(errs, fmt.Sprintf("%s: value %q does not match pattern %q", path, str, pattern))
+		}
+	}
+	return errs
+}
+
+func validateNumber(s map[string]interface{}, n float64, path string) []string {
+	var errs []string
+	if min, ok := s["minimum"].(float64); ok && n < min {
+		errs = append(errs, fmt.Sprintf("%s: value %v is less than %v", path, n, min))
+	}
+	if max, ok := s["maximum"].(float64); ok && n > max {
+		errs = append(errs, fmt.Sprintf("%s: value %v is greater than %v", path, n, max))
+	}
+	if min, ok := s["exclusiveMinimum"].(float64); ok && n <= min {
+		errs = append(errs, fmt.Sprintf("%s: value %v is not greater than %v", path, n, min))
+	}
+	if max, ok := s["exclusiveMaximum"].(float64); ok && n >= max {
+		errs = append(errs, fmt.Sprintf("%s: value %v is not less than %v", path, n, max))
+	}
+	return errs
+}
+
+func countValid(schemas []interface{}, value interface{}, path string) int {
+	valid := 0
+	for _, sub := range schemas {
+		if len(validateValue(sub, value, path)) == 0 {
+			valid++
+		}
+	}
+	return valid
+}
+
+func matchesType(t interface{}, value interface{}) bool {
+	if types, ok := t.([]interface{}); ok {
+		for _, single := range types {
+			if matchesType(single, value) {
+				return true
+			}
+		}
+		return false
+	}
+	actual := jsonType(value)
+	switch name := fmt.Sprint(t); name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		return actual == "number"
+	default:
+		return actual == name
+	}
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if equalValues(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalValues(a interface{}, b interface{}) bool {
+	ja, errA := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(a)
+	jb, errB := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -1,35 +1,114 @@
 package httpmock
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/httpmock/option"
+	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	jsoniter "github.com/json-iterator/go"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-type timedOut bool
-
 type Server struct {
-	Interactions *Interactions
-	Port         int
-	errorChannel chan error
-	httpServer   *http.Server
-	config       *Config
-	logger       *zap.Logger
+	Interactions   *Interactions
+	Port           int
+	errorChannel   chan error
+	runtimeErrors  chan error
+	httpServer     *http.Server
+	config         *Config
+	logger         *zap.Logger
+	notifiers      []notifier
+	notifyLock     sync.RWMutex
+	maxHeaderBytes int
+	t              TestingT
+	shutdownHooks  []func()
+	staticDirs     []staticDir
+	staticLock     sync.RWMutex
+	id             string
+	recorder       *Recorder
+	normalizer     func([]byte) []byte
+	tls            bool
+	tlsCertificate tls.Certificate
+	certificate    *x509.Certificate
+	fixedPort      int
+	unmatched      []RequestResponse
+	unmatchedLock  sync.Mutex
+	upstream       *url.URL
+	notFound       *notFoundResponse
+	corsOrigins    []string
+	closing        chan struct{}
+	requestLog     []*LoggedRequest
+	requestLogLock sync.Mutex
+	onRequest      []func(*http.Request, []byte)
+	onMatch        []func(*RequestResponse)
+	onNoMatch      []func(*http.Request)
+	quietRouter    bool
+	ginMode        string
+	ginMiddleware  []gin.HandlerFunc
+	autoHead       bool
+	autoOptions    bool
+	unixSocket     string
+	host           string
+	marshaller     func(interface{}) ([]byte, error)
+	defaults       map[string]notFoundResponse
+	defaultsLock   sync.RWMutex
+	middleware     []gin.HandlerFunc
+	basicAuth      *basicAuth
+	// multipartMaxMemory is the limit passed to multipart.Reader.ReadForm for captured forms.
+	multipartMaxMemory int64
+}
+
+type notFoundResponse struct {
+	status int
+	object interface{}
+}
+
+// TestingT is the subset of testing.TB used to report failed expectations.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// CapturedRequest is a snapshot of a request received by the server.
+type CapturedRequest struct {
+	Method  string
+	Path    string
+	Body    []byte
+	Headers http.Header
+	Query   url.Values
+	Matched bool
+}
+
+type notifier struct {
+	ch      chan<- CapturedRequest
+	options option.NotifyOptions
 }
 
 type Config struct {
+	// Deprecated: StartE returns as soon as the server is listening, so StartupWaitTimeout is not used anymore.
 	StartupWaitTimeout  time.Duration
 	ShutdownWaitTimeout time.Duration
 }
 
+const defaultHost = "127.0.0.1"
+
 var defaultConfig = &Config{
 	StartupWaitTimeout:  3 * time.Second,
 	ShutdownWaitTimeout: 15 * time.Second,
@@ -44,42 +123,239 @@ func StartDefaultHttpServer() *Server {
 
 func NewServer() *Server {
 	return &Server{
-		Interactions: NewInteractions(nil),
-		errorChannel: make(chan error),
+		Interactions:  NewInteractions(nil),
+		errorChannel:  make(chan error, 1),
+		runtimeErrors: make(chan error, 1),
+		logger:        zap.L(),
+		config:        defaultConfig,
 	}
 }
 
 func (s *Server) WithLogger(logger *zap.Logger) *Server {
+	if logger == nil {
+		logger = zap.L()
+	}
 	s.logger = logger
 	s.Interactions = NewInteractions(s.logger)
 	return s
 }
 
+// WithSilent drops all log output of the server: the logger is replaced by a no-op logger and the router is built
+// without gin's request logging middleware.
+func (s *Server) WithSilent() *Server {
+	s.setLogger(zap.NewNop())
+	s.quietRouter = true
+	return s
+}
+
+// WithLogLevel drops the log entries of the server below level. Above the info level gin's request logging
+// middleware is dropped as well, so that warnings and errors are kept without per-request output.
+func (s *Server) WithLogLevel(level zapcore.Level) *Server {
+	s.setLogger(s.logger.WithOptions(zap.IncreaseLevel(level)))
+	s.quietRouter = level > zapcore.InfoLevel
+	return s
+}
+
+// setLogger replaces the logger of the server and its interactions, keeping the registered interactions.
+func (s *Server) setLogger(logger *zap.Logger) {
+	s.logger = logger
+	s.Interactions.lock.Lock()
+	s.Interactions.logger = logger
+	s.Interactions.lock.Unlock()
+}
+
+// WithGinMode sets gin's mode, e.g. gin.ReleaseMode, when the server starts and builds the router with gin.New()
+// and only the given middleware, such as gin.Logger() or gin.Recovery(), instead of gin.Default().
+// The mode is global to gin and so applies to every gin router of the process.
+func (s *Server) WithGinMode(mode string, middleware ...gin.HandlerFunc) *Server {
+	s.ginMode = mode
+	s.ginMiddleware = middleware
+	return s
+}
+
+// newRouter returns gin's default router with its logger and recovery middleware, one with only the recovery
+// middleware when the server is quiet, or one with the middleware chosen by WithGinMode.
+func (s *Server) newRouter() *gin.Engine {
+	if s.ginMode != "" {
+		gin.SetMode(s.ginMode)
+		router := gin.New()
+		router.Use(s.ginMiddleware...)
+		return router
+	}
+	if !s.quietRouter {
+		return gin.Default()
+	}
+	router := gin.New()
+	router.Use(gin.Recovery())
+	return router
+}
+
+// WithMiddleware runs middleware, in the given order, before the mock handler for every request to the mock.
+// It runs after gin's built-in logger and recovery middleware, or the middleware given to WithGinMode, and is not
+// applied to the /__admin endpoints. Middleware that aborts the request keeps it from reaching the mock.
+func (s *Server) WithMiddleware(middleware ...gin.HandlerFunc) *Server {
+	s.middleware = append(s.middleware, middleware...)
+	return s
+}
+
 func (s *Server) WithConfig(config *Config) *Server {
+	if config == nil {
+		config = defaultConfig
+	}
 	s.config = config
 	return s
 }
 
+// WithMaxHeaderBytes rejects requests whose headers exceed n bytes with 431 Request Header Fields Too Large.
+// The limit is also passed to http.Server.MaxHeaderBytes; net/http adds 4096 bytes of slack to it and answers 431
+// itself before the mock sees the request, so the handler checks the exact limit for requests that get through.
+func (s *Server) WithMaxHeaderBytes(n int) *Server {
+	s.maxHeaderBytes = n
+	return s
+}
+
+// WithNotFoundResponse replaces the 501 error returned when no interaction matches a request with status and obj.
+// A nil obj responds with the status code only.
+func (s *Server) WithNotFoundResponse(status int, obj interface{}) *Server {
+	s.notFound = &notFoundResponse{status: status, object: obj}
+	return s
+}
+
+// WithT reports failed call expectations to t when the server shuts down.
+func (s *Server) WithT(t TestingT) *Server {
+	s.t = t
+	return s
+}
+
+// OnShutdown registers fn to run once the server has shut down. Hooks run in registration order.
+func (s *Server) OnShutdown(fn func()) *Server {
+	s.shutdownHooks = append(s.shutdownHooks, fn)
+	return s
+}
+
+// WithID names the server in the log of a shared Recorder. Defaults to the server address.
+func (s *Server) WithID(id string) *Server {
+	s.id = id
+	return s
+}
+
+// WithSharedRecorder appends every request received by the server to r, so that the order of calls
+// can be asserted across several servers sharing the same recorder.
+func (s *Server) WithSharedRecorder(r *Recorder) *Server {
+	s.recorder = r
+	return s
+}
+
+// WithCaptureNormalizer transforms every captured request body with normalizer before it is stored in
+// CapturedRequestBody and passed to the RequestCaptureFunc, e.g. to sort keys or strip volatile fields.
+// The normalizer runs once per captured request; the body as received stays available in RawCapturedRequestBody.
+func (s *Server) WithCaptureNormalizer(normalizer func([]byte) []byte) *Server {
+	s.normalizer = normalizer
+	return s
+}
+
+// WithPort makes Start listen on port instead of a random free port.
+func (s *Server) WithPort(port int) *Server {
+	s.fixedPort = port
+	return s
+}
+
+// WithHost makes Start listen on host, e.g. "0.0.0.0" to accept requests from containers, instead of 127.0.0.1.
+func (s *Server) WithHost(host string) *Server {
+	s.host = host
+	return s
+}
+
+func (s *Server) bindHost() string {
+	if s.host == "" {
+		return defaultHost
+	}
+	return s.host
+}
+
+// urlHost returns the host for URLs of the server: the address it listens on, or localhost if it listens on all
+// addresses. The default 127.0.0.1 is not replaced by localhost, which some clients resolve to ::1 only.
+func (s *Server) urlHost() string {
+	switch host := s.bindHost(); host {
+	case "0.0.0.0", "::":
+		return "localhost"
+	default:
+		return host
+	}
+}
+
+// Start starts the server and panics if it fails to do so. Use StartE to handle the error instead.
 func (s *Server) Start() *Server {
-	router := gin.Default()
-	s.Port = findFreePort(s.logger)
-	s.httpServer = &http.Server{Addr: fmt.Sprintf(":%d", s.Port), Handler: router}
+	if _, err := s.StartE(); err != nil {
+		s.logger.Panic("failed to start http mock server", zap.Error(err))
+	}
+	return s
+}
+
+// StartE starts the server and returns an error if it could not bind its port or failed during startup.
+func (s *Server) StartE() (*Server, error) {
+	router := s.newRouter()
+	listener, err := s.listen()
+	if err != nil {
+		return s, err
+	}
+	addr := listener.Addr().String()
+	if s.unixSocket == "" {
+		addr = net.JoinHostPort(s.bindHost(), strconv.Itoa(s.Port))
+	}
+	s.httpServer = &http.Server{Addr: addr, Handler: router, MaxHeaderBytes: s.maxHeaderBytes}
+	s.closing = make(chan struct{})
+	s.registerAdminRoutes(router)
+	router.Use(s.middleware...)
 	router.NoRoute(s.handler)
+	if s.tls {
+		if s.httpServer.TLSConfig, err = s.tlsConfig(); err != nil {
+			s.httpServer = nil
+			_ = listener.Close()
+			return s, err
+		}
+	}
 
 	go func() {
-		s.logger.Info("Starting mock web server", zap.String("addr", s.httpServer.Addr))
-		if err := s.httpServer.ListenAndServe(); err != nil {
-			s.errorChannel <- err
+		s.logger.Info("Starting mock web server", zap.String("addr", s.httpServer.Addr), zap.Bool("tls", s.tls))
+		var err error
+		if s.tls {
+			err = s.httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = s.httpServer.Serve(listener)
+		}
+		if err == nil {
+			return
+		}
+		// errorChannel has room for the single error of each run, so the send never blocks
+		// even if nobody is waiting for it.
+		s.errorChannel <- err
+		if !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("mock web server stopped", zap.Error(err))
+			select {
+			case s.runtimeErrors <- err:
+			default:
+			}
 		}
 	}()
 
-	if timeout, er := wait(s.config.StartupWaitTimeout, s.errorChannel); timeout == false {
-		s.logger.Panic("failed to start http mock server, reason - timeout", zap.Error(er))
-	} else {
-		s.logger.Info("Started mock web Server", zap.String("addr", s.httpServer.Addr))
+	// the listener is already bound, so only an error Serve returned right away is reported
+	select {
+	case err := <-s.errorChannel:
+		s.httpServer = nil
+		return s, fmt.Errorf("mock web server stopped during startup: %w", err)
+	default:
 	}
+	s.logger.Info("Started mock web Server", zap.String("addr", s.httpServer.Addr))
 
-	return s
+	return s, nil
+}
+
+// Err returns a channel receiving the error that stops the server when it fails while serving, e.g. because its
+// listener broke. Failures during startup are sent as well, in addition to being returned by StartE.
+// A server stopped by Shutdown or ShutdownE sends nothing; their failures are reported by ShutdownE.
+func (s *Server) Err() <-chan error {
+	return s.runtimeErrors
 }
 
 type errorResponse struct {
@@ -88,6 +364,13 @@ type errorResponse struct {
 	Method  string `json:"method"`
 }
 
+type schemaErrorResponse struct {
+	Message string   `json:"message"`
+	Path    string   `json:"path"`
+	Method  string   `json:"method"`
+	Errors  []string `json:"errors"`
+}
+
 func newErr(c *gin.Context) errorResponse {
 	return errorResponse{
 		Message: "[MOCK WEB SERVER ERROR] does not have (any more) mock interactions for path/method",
@@ -97,42 +380,517 @@ func newErr(c *gin.Context) errorResponse {
 }
 
 func (s *Server) handler(c *gin.Context) {
-	bodyBytes := s.getBody(c)
+	received := time.Now()
+	logged := s.logRequest(c, received)
+	if s.maxHeaderBytes > 0 && headerSize(c.Request.Header) > s.maxHeaderBytes {
+		s.logger.Warn("responding with 431 since request headers are too large", zap.Int("maxHeaderBytes", s.maxHeaderBytes))
+		c.Status(http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	if s.cors(c) {
+		return
+	}
+	if !s.authorized(c) {
+		return
+	}
+
+	if s.recorder != nil {
+		s.recorder.record(RecordedCall{ServerID: s.ID(), Method: c.Request.Method, Path: c.Request.URL.Path, Time: time.Now()})
+	}
+
+	if strings.EqualFold(c.GetHeader("Expect"), "100-continue") {
+		if mock, pathParams, reason := s.nextMatchingInteraction(c.Request, nil, matchExpectContinue); mock != nil {
+			s.respondToExpectContinue(c, logged, mock, pathParams, reason)
+			return
+		}
+	}
+
+	bodyBytes, err := s.getBody(c)
+	s.setLogged(logged, bodyBytes, false)
+	if err != nil {
+		s.logger.Warn("responding with 400 since the request body could not be decompressed", zap.Error(err))
+		c.Status(http.StatusBadRequest)
+		return
+	}
 
 	s.logger.Info("request to mock server", zap.String("method", c.Request.Method), zap.Any("url", c.Request.URL), zap.Any("headers", c.Request.Header), zap.String("body", string(bodyBytes)))
+	s.runRequestHooks(c.Request, bodyBytes)
 
-	mock := s.Interactions.NextInteraction(c.Request.Method, c.Request.URL.Path)
-	if mock != nil {
-		if mock.DelayResponse > 0 {
-			s.logger.Info("delaying response", zap.Duration("duration", mock.DelayResponse))
-			time.Sleep(mock.DelayResponse)
+	if schema := s.Interactions.requestSchema(c.Request); schema != nil {
+		if violations := validateSchema(schema, bodyBytes); len(violations) > 0 {
+			s.logger.Warn("responding with 400 since the request body violates the schema", zap.Strings("violations", violations))
+			c.JSON(http.StatusBadRequest, schemaErrorResponse{
+				Message: "[MOCK WEB SERVER ERROR] request body does not match the schema",
+				Path:    c.Request.URL.Path,
+				Method:  c.Request.Method,
+				Errors:  violations,
+			})
+			return
 		}
-		mock.Capture(bodyBytes, c.Request.Header)
-		if mock.ResponseObject != nil {
-			resp, _ := jsoniter.Marshal(mock.ResponseObject)
-			s.logger.Info("responding with", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.String("body", string(resp)))
+	}
 
-			if mock.ResponseContentType == "XML" {
-				c.XML(mock.ResponseHttpStatus, mock.ResponseObject)
+	mock, pathParams, reason := s.nextMatchingInteraction(c.Request, bodyBytes, matchAdmitted)
+	s.setLogged(logged, bodyBytes, mock != nil && reason == notRejected)
+	if mock != nil && s.rejected(c, mock, reason) {
+		return
+	}
+	s.runMatchHooks(c.Request, mock, pathParams)
+	if mock != nil {
+		if mock.ConcurrencyLimit > 0 {
+			release, acquired := s.acquire(c, mock)
+			if !acquired {
+				s.logger.Warn("responding with 503 since the request was cancelled while queued", zap.Int("limit", mock.ConcurrencyLimit))
+				c.Status(http.StatusServiceUnavailable)
+				return
+			}
+			defer release()
+		}
+		defer func(mock *RequestResponse) {
+			s.Interactions.setElapsed(mock, time.Since(received))
+		}(mock)
+		if mock.RateLimit != nil {
+			if calls := s.Interactions.countRateLimitedCall(mock.Method, mock.Path); calls > *mock.RateLimit {
+				s.logger.Warn("responding with 429 since rate limit is exceeded", zap.Int("calls", calls), zap.Int("limit", *mock.RateLimit))
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(mock.RetryAfter.Seconds()))))
+				c.Status(http.StatusTooManyRequests)
+				return
+			}
+		}
+		if delay := mock.delay(); delay > 0 {
+			s.logger.Info("delaying response", zap.Duration("duration", delay))
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-c.Request.Context().Done():
+				timer.Stop()
+				s.logger.Info("request cancelled during delay", zap.Error(c.Request.Context().Err()))
+				return
+			}
+		}
+		if !s.capture(c, mock, pathParams, bodyBytes, received) {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		s.notify(c, bodyBytes, true)
+		if mock.Fault != option.FaultNone {
+			s.writeFault(c, mock.Fault)
+			return
+		}
+		if mock.Hang {
+			s.hang(c)
+			return
+		}
+		if mock.ResponseFunc != nil {
+			status, obj, headers := mock.ResponseFunc(c.Request, bodyBytes)
+			for name, values := range headers {
+				for _, value := range values {
+					c.Writer.Header().Add(name, value)
+				}
+			}
+			dynamic := s.Interactions.snapshot(mock)
+			dynamic.ResponseHttpStatus = status
+			dynamic.ResponseObject = obj
+			mock = &dynamic
+		}
+		if len(mock.Representations) > 0 {
+			negotiated := s.Interactions.snapshot(mock)
+			negotiated.ResponseContentType, negotiated.ResponseObject = negotiate(c.GetHeader("Accept"), mock.Representations)
+			s.logger.Info("negotiated response content type", zap.String("contentType", negotiated.ResponseContentType))
+			c.Writer.Header().Add("Vary", "Accept")
+			mock = &negotiated
+		}
+		if mock.ResponseTemplate != nil {
+			templated := s.Interactions.snapshot(mock)
+			body, err := renderTemplate(&templated, pathParams, c.Request, bodyBytes)
+			if err != nil {
+				s.logger.Error("failed to render response template", zap.Error(err))
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			templated.RawResponseBody = body
+			mock = &templated
+		}
+		if mock.ChecksumField != "" {
+			obj, err := withChecksum(mock.ResponseObject, mock.ChecksumField, mock.ChecksumAlgo, bodyBytes)
+			if err != nil {
+				s.logger.Error("failed to add checksum to response object", zap.Error(err))
+				c.Status(http.StatusInternalServerError)
 				return
 			}
-			c.JSON(mock.ResponseHttpStatus, mock.ResponseObject)
+			withSum := s.Interactions.snapshot(mock)
+			withSum.ResponseObject = obj
+			mock = &withSum
+		}
+		writeHeaders(c, mock)
+		if mock.RedirectLocation != "" {
+			s.redirect(c, mock)
+			return
+		}
+		if !bodyAllowed(mock.ResponseHttpStatus) {
+			if hasBody(mock) {
+				s.logger.Warn("dropping response body since the status does not allow one", zap.Int("httpStatus", mock.ResponseHttpStatus))
+			}
+			c.Status(mock.ResponseHttpStatus)
+			return
+		}
+		if (mock.RangeRequests || mock.ResponseBodyFile != "") && c.GetHeader("Range") != "" {
+			s.writeRange(c, mock)
+			return
+		}
+		if mock.CloseDelimited || mock.ReasonPhrase != "" {
+			s.writeCloseDelimited(c, mock)
+			return
+		}
+		if len(mock.SSEEvents) > 0 {
+			s.writeSSE(c, mock)
+			return
+		}
+		if mock.StreamedChunks != nil {
+			s.writeStream(c, mock)
+			return
+		}
+		if mock.ResponseReader != nil {
+			s.writeReader(c, mock)
+		} else if mock.ResponseBodyFile != "" {
+			body, contentType, err := s.renderBody(mock)
+			if err != nil {
+				s.logger.Error("failed to read response body file", zap.String("file", mock.ResponseBodyFile), zap.Error(err))
+				c.JSON(http.StatusInternalServerError, errorResponse{
+					Message: "[MOCK WEB SERVER ERROR] failed to read response body file: " + err.Error(),
+					Path:    c.Request.URL.Path,
+					Method:  c.Request.Method,
+				})
+				return
+			}
+			s.logger.Info("responding with body file", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.String("file", mock.ResponseBodyFile))
+			c.Header("Accept-Ranges", "bytes")
+			s.writeBody(c, mock, contentType, body)
+		} else if mock.RawResponseBody != nil {
+			s.logger.Info("responding with raw body", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.String("body", string(mock.RawResponseBody)))
+			s.writeBody(c, mock, contentType(mock.ResponseContentType), mock.RawResponseBody)
+		} else if mock.ResponseObject != nil {
+			body, contentType, err := s.renderBody(mock)
+			if err != nil {
+				s.logger.Error("failed to serialize response object", zap.String("contentType", contentType), zap.Error(err))
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			s.logger.Info("responding with", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.String("body", string(body)))
+			s.writeBody(c, mock, contentType, body)
 		} else {
 			s.logger.Info("responding with status code only", zap.Int("httpStatus", mock.ResponseHttpStatus))
 			c.Status(mock.ResponseHttpStatus)
 		}
+	} else if methods := s.allowedMethods(c.Request); len(methods) > 0 {
+		s.logger.Info("responding to OPTIONS with the registered methods", zap.Strings("methods", methods))
+		c.Header("Allow", strings.Join(methods, ", "))
+		c.Status(http.StatusNoContent)
+	} else if sd, ok := s.staticDirFor(c.Request); ok {
+		s.serveFile(c, sd)
+	} else if s.upstream != nil {
+		s.proxy(c, bodyBytes)
 	} else {
+		s.recordUnmatched(c, bodyBytes)
+		s.notify(c, bodyBytes, false)
+		s.respondNotFound(c)
+	}
+}
+
+// writeReader streams the body from a fresh reader of the interaction, with a Content-Length if the reader knows it.
+func (s *Server) writeReader(c *gin.Context, mock *RequestResponse) {
+	reader := mock.ResponseReader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	length := int64(-1)
+	if sized, ok := reader.(interface{ Len() int }); ok {
+		length = int64(sized.Len())
+	}
+	s.logger.Info("responding with body from reader", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.Int64("length", length))
+	c.DataFromReader(mock.ResponseHttpStatus, length, contentType(mock.ResponseContentType), reader, nil)
+}
+
+// writeBody writes the response body, compressed with gzip if the interaction and the request allow it.
+func (s *Server) writeBody(c *gin.Context, mock *RequestResponse, contentType string, body []byte) {
+	if !mock.GzipResponse || !acceptsGzip(c.Request) {
+		c.Data(mock.ResponseHttpStatus, contentType, body)
+		return
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write(body)
+	_ = zw.Close()
+	c.Header("Content-Encoding", "gzip")
+	c.Writer.Header().Add("Vary", "Accept-Encoding")
+	c.Data(mock.ResponseHttpStatus, contentType, compressed.Bytes())
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name := strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+		if strings.EqualFold(name, "gzip") && !strings.HasSuffix(strings.ReplaceAll(encoding, " ", ""), ";q=0") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDefault answers requests of method without a matching interaction with status and obj, a nil obj responding
+// with the status code only. The default takes precedence over WithNotFoundResponse and the 501 error.
+func (s *Server) SetDefault(method string, status int, obj interface{}) {
+	s.defaultsLock.Lock()
+	defer s.defaultsLock.Unlock()
+	if s.defaults == nil {
+		s.defaults = map[string]notFoundResponse{}
+	}
+	s.defaults[method] = notFoundResponse{status: status, object: obj}
+}
+
+func (s *Server) respondNotFound(c *gin.Context) {
+	s.defaultsLock.RLock()
+	fallback, ok := s.defaults[c.Request.Method]
+	s.defaultsLock.RUnlock()
+	switch {
+	case ok:
+		s.logger.Info("responding with the default response of the method since no interactions were found", zap.Int("httpStatus", fallback.status))
+		s.writeNotFound(c, fallback)
+	case s.notFound == nil:
 		s.logger.Warn("responding with error 501 since no interactions were found")
 		c.JSON(http.StatusNotImplemented, newErr(c))
+	default:
+		s.logger.Warn("responding with not found response since no interactions were found", zap.Int("httpStatus", s.notFound.status))
+		s.writeNotFound(c, *s.notFound)
+	}
+}
+
+func (s *Server) writeNotFound(c *gin.Context, resp notFoundResponse) {
+	if resp.object == nil {
+		c.Status(resp.status)
+		return
+	}
+	body, err := s.marshal(resp.object)
+	if err != nil {
+		s.logger.Error("failed to serialize not found response", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(resp.status, "application/json; charset=utf-8", body)
+}
+
+// capture captures the request for mock and reports whether its capture funcs ran without panicking.
+func (s *Server) capture(c *gin.Context, mock *RequestResponse, pathParams map[string]string, body []byte, received time.Time) bool {
+	req := capturedRequest{
+		body:       body,
+		rawBody:    body,
+		headers:    c.Request.Header,
+		query:      c.Request.URL.Query(),
+		url:        c.Request.RequestURI,
+		form:       s.parseForm(body, c.Request.Header),
+		at:         received,
+		pathParams: pathParams,
+	}
+	if s.normalizer != nil {
+		req.body = s.normalizer(body)
+	}
+	if err := s.Interactions.capture(mock, req); err != nil {
+		s.logger.Error("responding with 500 since the request capture func panicked", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// acquire takes a slot of the concurrency limit of the request's method and path, waiting for one to free up if the
+// interaction queues overflowing requests. With OverflowReject the slot was already taken while matching the request.
+func (s *Server) acquire(c *gin.Context, mock *RequestResponse) (release func(), acquired bool) {
+	sem := s.Interactions.inFlight(mock.Method, mock.Path)
+	if sem == nil {
+		return func() {}, true
+	}
+	release = func() { <-sem }
+
+	if mock.Overflow == option.OverflowReject {
+		return release, true
+	}
+	select {
+	case sem <- struct{}{}:
+		return release, true
+	case <-c.Request.Context().Done():
+		return nil, false
+	}
+}
+
+// redirect responds with the redirect status and Location header only. Statuses outside 3xx are replaced by 302 Found.
+func (s *Server) redirect(c *gin.Context, mock *RequestResponse) {
+	status := mock.ResponseHttpStatus
+	if status < 300 || status > 399 {
+		status = http.StatusFound
+	}
+	s.logger.Info("responding with redirect", zap.Int("httpStatus", status), zap.String("location", mock.RedirectLocation))
+	c.Status(status)
+}
+
+// rejected responds with 503 and reports true if the request was rejected by the degrade threshold or the concurrency
+// limit of mock. Callers log rejected requests as unmatched and skip the match hooks, since the interaction was not used.
+func (s *Server) rejected(c *gin.Context, mock *RequestResponse, reason rejection) bool {
+	switch reason {
+	case rejectedDegraded:
+		s.logger.Warn("responding with 503 since request rate is above threshold", zap.Float64("rate", s.Interactions.RequestRate(mock.Method, mock.Path)), zap.Float64("threshold", mock.DegradeAboveRate))
+	case rejectedConcurrency:
+		s.logger.Warn("responding with 503 since concurrency limit is reached", zap.Int("limit", mock.ConcurrencyLimit))
+	default:
+		return false
+	}
+	c.Status(http.StatusServiceUnavailable)
+	return true
+}
+
+// respondToExpectContinue answers with a final status without reading the body, so net/http never sends "100 Continue".
+// Apart from the body, the request is hooked, logged, captured and notified like any other matched request.
+func (s *Server) respondToExpectContinue(c *gin.Context, logged *LoggedRequest, mock *RequestResponse, pathParams map[string]string, reason rejection) {
+	s.logger.Info("request to mock server", zap.String("method", c.Request.Method), zap.Any("url", c.Request.URL), zap.Any("headers", c.Request.Header))
+	s.runRequestHooks(c.Request, nil)
+	s.setLogged(logged, nil, reason == notRejected)
+	if s.rejected(c, mock, reason) {
+		return
+	}
+	s.runMatchHooks(c.Request, mock, pathParams)
+	if mock.ConcurrencyLimit > 0 {
+		release, acquired := s.acquire(c, mock)
+		if !acquired {
+			s.logger.Warn("responding with 503 since the request was cancelled while queued", zap.Int("limit", mock.ConcurrencyLimit))
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+	}
+
+	status := http.StatusExpectationFailed
+	if mock.ExpectContinue == option.ExpectContinueFinalStatus {
+		status = mock.ResponseHttpStatus
+	}
+	if err := s.Interactions.capture(mock, capturedRequest{headers: c.Request.Header, query: c.Request.URL.Query(), url: c.Request.RequestURI, at: time.Now(), pathParams: pathParams}); err != nil {
+		s.logger.Error("responding with 500 since the request capture func panicked", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	s.notify(c, nil, true)
+	s.logger.Info("responding to Expect: 100-continue without reading the body", zap.Int("httpStatus", status))
+	writeHeaders(c, mock)
+	c.Status(status)
+}
+
+// writeCloseDelimited hijacks the connection, writes an HTTP/1.0 response without Content-Length
+// and closes the connection so that the client reads the body until EOF.
+// The status line carries the reason phrase of the interaction, if any.
+func (s *Server) writeCloseDelimited(c *gin.Context, mock *RequestResponse) {
+	body, contentType, err := s.renderBody(mock)
+	if err != nil {
+		s.logger.Error("failed to marshal response object", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	conn, buf, err := c.Writer.Hijack()
+	if err != nil {
+		s.logger.Error("failed to hijack connection for close delimited response", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	s.logger.Info("responding with close delimited body", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.String("body", string(body)))
+	reason := mock.ReasonPhrase
+	if reason == "" {
+		reason = http.StatusText(mock.ResponseHttpStatus)
+	}
+	_, _ = fmt.Fprintf(buf, "HTTP/1.0 %d %s\r\n", mock.ResponseHttpStatus, reason)
+	if len(body) > 0 {
+		_, _ = fmt.Fprintf(buf, "Content-Type: %s\r\n", contentType)
+	}
+	_ = c.Writer.Header().Write(buf)
+	_, _ = buf.WriteString("Connection: close\r\n\r\n")
+	_, _ = buf.Write(body)
+	if err := buf.Flush(); err != nil {
+		s.logger.Warn("failed to write close delimited response", zap.Error(err))
+	}
+}
+
+func writeHeaders(c *gin.Context, mock *RequestResponse) {
+	for name, values := range mock.ResponseHeaders {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	if len(mock.ServerTiming) > 0 {
+		c.Header("Server-Timing", serverTiming(mock.ServerTiming))
+	}
+	if mock.RedirectLocation != "" {
+		c.Header("Location", mock.RedirectLocation)
+	}
+	for _, cookie := range mock.ResponseCookies {
+		http.SetCookie(c.Writer, cookie)
+	}
+}
+
+// serverTiming formats metrics as a Server-Timing header value, sorted by metric name.
+func serverTiming(metrics map[string]time.Duration) string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]string, 0, len(names))
+	for _, name := range names {
+		millis := float64(metrics[name]) / float64(time.Millisecond)
+		entries = append(entries, name+";dur="+strconv.FormatFloat(millis, 'f', -1, 64))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// headerSize approximates the wire size of the headers as "Name: value\r\n" lines.
+func headerSize(headers http.Header) int {
+	size := 0
+	for name, values := range headers {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
 	}
+	return size
 }
 
-func (s *Server) getBody(c *gin.Context) []byte {
+// getBody reads the request body, decompressing it if needed, and puts the body as received back into the request
+// so that hooks and middleware running afterwards can read it again.
+func (s *Server) getBody(c *gin.Context) ([]byte, error) {
+	body := c.Request.Body
 	defer func() {
-		_ = c.Request.Body.Close()
+		_ = body.Close()
 	}()
-	bodyBytes, _ := ioutil.ReadAll(c.Request.Body)
-	return bodyBytes
+	bodyBytes, _ := ioutil.ReadAll(body)
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(bodyBytes))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(bodyBytes))
+	default:
+		return bodyBytes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	return ioutil.ReadAll(reader)
 }
 
 // AddInteraction adds a new interaction into the server
@@ -140,44 +898,216 @@ func (s *Server) AddInteraction(method string, path string, responseStatus int,
 	s.Interactions.Add(method, path, responseStatus, responseObject, responseContentType, requestCaptureFunc, opts...)
 }
 
+// NotifyOn sends a CapturedRequest to ch for every matched request once it has been captured.
+// By default the send does not block and the notification is dropped when ch is not ready; use a buffered channel or
+// option.WithBlockingNotify to avoid losing notifications.
+func (s *Server) NotifyOn(ch chan<- CapturedRequest, opts ...option.NotifyOptionFunc) *Server {
+	s.notifyLock.Lock()
+	defer s.notifyLock.Unlock()
+	s.notifiers = append(s.notifiers, notifier{ch: ch, options: option.ProcessNotifyOptions(s.logger, opts)})
+	return s
+}
+
+func (s *Server) notify(c *gin.Context, body []byte, matched bool) {
+	s.notifyLock.RLock()
+	defer s.notifyLock.RUnlock()
+
+	for _, n := range s.notifiers {
+		if !matched && !n.options.IncludeUnmatched ||
+			n.options.Method != "" && n.options.Method != c.Request.Method ||
+			n.options.Path != "" && n.options.Path != c.Request.URL.Path {
+			continue
+		}
+		captured := CapturedRequest{
+			Method:  c.Request.Method,
+			Path:    c.Request.URL.Path,
+			Body:    append([]byte(nil), body...),
+			Headers: c.Request.Header.Clone(),
+			Query:   c.Request.URL.Query(),
+			Matched: matched,
+		}
+		if n.options.Blocking {
+			select {
+			case n.ch <- captured:
+			case <-c.Request.Context().Done():
+				s.logger.Warn("request cancelled before notification was delivered")
+			}
+			continue
+		}
+		select {
+		case n.ch <- captured:
+		default:
+			s.logger.Warn("dropping request notification since channel is not ready")
+		}
+	}
+}
+
+// URL returns the base URL of the server, e.g. http://127.0.0.1:8080, or https://127.0.0.1:8080 with TLS.
+// With a unix domain socket it has no port, e.g. http://localhost.
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.tls {
+		scheme = "https"
+	}
+	if s.unixSocket != "" {
+		return scheme + "://localhost"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(s.urlHost(), strconv.Itoa(s.Port)))
+}
+
+// URLFor returns the URL of path on the server.
+func (s *Server) URLFor(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return s.URL() + path
+}
+
+// ID returns the name of the server as set by WithID, or its address.
+func (s *Server) ID() string {
+	if s.id != "" {
+		return s.id
+	}
+	if s.unixSocket != "" {
+		return "unix:" + s.unixSocket
+	}
+	return net.JoinHostPort(s.urlHost(), strconv.Itoa(s.Port))
+}
+
+// UnmatchedRequests returns the requests for which no interaction was found, in the order they were received.
+func (s *Server) UnmatchedRequests() []RequestResponse {
+	s.unmatchedLock.Lock()
+	defer s.unmatchedLock.Unlock()
+	return append([]RequestResponse(nil), s.unmatched...)
+}
+
+func (s *Server) recordUnmatched(c *gin.Context, body []byte) {
+	s.unmatchedLock.Lock()
+	defer s.unmatchedLock.Unlock()
+	s.unmatched = append(s.unmatched, RequestResponse{
+		Method:                 c.Request.Method,
+		Path:                   c.Request.URL.Path,
+		CapturedRequestBody:    body,
+		RawCapturedRequestBody: body,
+		CapturedRequestHeaders: c.Request.Header.Clone(),
+	})
+}
+
+// WaitForCall blocks until the server has captured a request for the method and path or the timeout expires,
+// see Interactions.WaitForCall.
+func (s *Server) WaitForCall(method string, path string, timeout time.Duration) (*RequestResponse, error) {
+	return s.Interactions.WaitForCall(method, path, timeout)
+}
+
+// ResetPath clears the interactions, call count and unmatched requests of the method and path only.
+func (s *Server) ResetPath(method string, path string) {
+	s.Interactions.ResetKey(method, path)
+	s.unmatchedLock.Lock()
+	defer s.unmatchedLock.Unlock()
+	unmatched := s.unmatched[:0]
+	for _, rr := range s.unmatched {
+		if rr.Method != method || rr.Path != path {
+			unmatched = append(unmatched, rr)
+		}
+	}
+	s.unmatched = unmatched
+}
+
 func (s *Server) Reset() {
 	s.Interactions.Reset()
+	s.unmatchedLock.Lock()
+	s.unmatched = nil
+	s.unmatchedLock.Unlock()
+	s.requestLogLock.Lock()
+	s.requestLog = nil
+	s.requestLogLock.Unlock()
 }
 
+// ErrNotStarted is returned by ShutdownE for a server that was never started.
+var ErrNotStarted = errors.New("mock web server was not started")
+
+// Shutdown shuts the server down, logging any failure. Use ShutdownE to handle the error instead.
 func (s *Server) Shutdown() {
-	s.logger.Info("Shutting down mock web server HTTP Server", zap.String("addr", s.httpServer.Addr))
-	if err := s.httpServer.Shutdown(context.Background()); err != nil {
+	if err := s.ShutdownE(context.Background()); err != nil && !errors.Is(err, ErrNotStarted) {
 		s.logger.Error("Failed to shut down server", zap.Error(err))
 	}
-	if timeout, err := wait(s.config.ShutdownWaitTimeout, s.errorChannel); timeout {
-		s.logger.Error("timed out waiting for mock web Server to shut down")
-	} else {
-		s.logger.Sugar().Infof("Server shut down: %v", err)
-	}
 }
 
-func wait(timeout time.Duration, errorChannel chan error) (timedOut, error) {
+// ShutdownE gracefully shuts the server down and waits for it to stop, giving up when ctx is done or
+// after Config.ShutdownWaitTimeout, whichever comes first.
+// Call expectations are verified and OnShutdown hooks run even if the shutdown fails.
+// A server that was never started returns ErrNotStarted without running either.
+func (s *Server) ShutdownE(ctx context.Context) error {
+	if s.httpServer == nil {
+		return ErrNotStarted
+	}
+	defer s.afterShutdown()
+	defer s.removeSocket()
+
+	s.logger.Info("Shutting down mock web server HTTP Server", zap.String("addr", s.httpServer.Addr))
+	select {
+	case <-s.closing:
+	default:
+		close(s.closing)
+	}
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down mock web server: %w", err)
+	}
+
 	select {
-	case err := <-errorChannel:
-		return false, err
-	case <-time.After(timeout):
-		return true, nil
+	case err := <-s.errorChannel:
+		s.logger.Sugar().Infof("Server shut down: %v", err)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("mock web server did not shut down: %w", ctx.Err())
+	case <-time.After(s.config.ShutdownWaitTimeout):
+		return errors.New("timed out waiting for mock web Server to shut down")
 	}
 }
 
-func findFreePort(logger *zap.Logger) (port int) {
-	addr, resolveAddressError := net.ResolveTCPAddr("tcp", "localhost:0")
-	if resolveAddressError != nil {
-		logger.Sugar().Panicf("unable to resolve a random IP address on localhost : %v", resolveAddressError)
+func (s *Server) afterShutdown() {
+	for _, err := range s.ExpectationErrors() {
+		s.logger.Error("call expectation not met", zap.Error(err))
+		if s.t != nil {
+			s.t.Errorf("%v", err)
+		}
+	}
+	for _, hook := range s.shutdownHooks {
+		hook()
 	}
-	listen, listenError := net.ListenTCP("tcp", addr)
-	if listenError != nil {
-		logger.Sugar().Panicf("unable to listen on %v which assigning random port : %v", addr, listenError)
+}
+
+// ExpectationErrors returns an error for every method and path whose call count does not match option.WithExpectedCalls.
+func (s *Server) ExpectationErrors() []error {
+	return s.Interactions.VerifyExpectations()
+}
+
+// listen binds the unix domain socket of the server, or its port and sets Port.
+func (s *Server) listen() (net.Listener, error) {
+	if s.unixSocket != "" {
+		listener, err := net.Listen("unix", s.unixSocket)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen on unix socket %s : %w", s.unixSocket, err)
+		}
+		return listener, nil
 	}
-	if listenCloseError := listen.Close(); listenCloseError != nil {
-		logger.Sugar().Panicf("unable to Close TCP listener on %v : %v", addr, listenCloseError)
+	listener, err := listen(s.bindHost(), s.fixedPort)
+	if err != nil {
+		return nil, err
 	}
+	s.Port = listener.Addr().(*net.TCPAddr).Port
+	return listener, nil
+}
 
-	port = listen.Addr().(*net.TCPAddr).Port
-	return
+// listen binds port on host, or a random free port if it is 0. The listener is handed to the http server as is,
+// so no other process can take the port between finding and serving it.
+func listen(host string, port int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		if port == 0 {
+			return nil, fmt.Errorf("unable to listen on a random port of %s : %w", host, err)
+		}
+		return nil, fmt.Errorf("unable to listen on %s:%d, it may already be in use : %w", host, port, err)
+	}
+	return listener, nil
 }
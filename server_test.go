@@ -1,17 +1,34 @@
 package httpmock
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"github.com/httpmock/option"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestMockServer_AddInteraction(t *testing.T) {
@@ -130,22 +147,110 @@ func TestMockServer_AddInteractionConcurrently(t *testing.T) {
 	var wg sync.WaitGroup
 
 	for i := 0; i < 100; i++ {
+		wg.Add(1)
 		go func(index int) {
-			wg.Add(1)
 			defer wg.Done()
 
 			server.AddInteraction(http.MethodPost, "/entitlement", http.StatusAccepted, response, responseContentType, nil)
 			req, _ := http.NewRequest(http.MethodPost, uri, nil)
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
-				t.Fatalf("failed to call mock: %v", err)
+				t.Errorf("failed to call mock: %v", err)
+				return
 			}
+			_ = resp.Body.Close()
 
 			assert.Equalf(t, http.StatusAccepted, resp.StatusCode, "index: %v", index)
 		}(i)
 	}
 
 	wg.Wait()
+	assert.Equal(t, 100, server.Interactions.CallCount(http.MethodPost, "/entitlement"))
+}
+
+// TestMockServer_AddInteractionWhileServing interleaves registrations and requests for the same path; run it with
+// -race. Every request either gets a registered interaction or a 501, and every interaction is used at most once.
+func TestMockServer_AddInteractionWhileServing(t *testing.T) {
+	server := StartDefaultHttpServer()
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	statuses := map[int]int{}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			server.AddInteraction(http.MethodGet, "/interleaved", http.StatusOK, map[string]int{"n": 1}, "JSON", nil)
+		}()
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URLFor("/interleaved"))
+			if err != nil {
+				t.Errorf("failed to call mock: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+			lock.Lock()
+			statuses[resp.StatusCode]++
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, statuses[http.StatusOK]+statuses[http.StatusNotImplemented])
+	assert.Equal(t, statuses[http.StatusOK], server.Interactions.CallCount(http.MethodGet, "/interleaved"))
+	assert.Len(t, server.Interactions.Unconsumed(), 50-statuses[http.StatusOK])
+}
+
+// TestMockServer_ParallelSelection sends as many concurrent requests as there are queued interactions for a key;
+// run it with -race. Every interaction must be served exactly once, with no duplicates or skips.
+func TestMockServer_ParallelSelection(t *testing.T) {
+	const n = 200
+	server := StartDefaultHttpServer()
+	defer server.Shutdown()
+	for i := 0; i < n; i++ {
+		server.AddInteraction(http.MethodPost, "/parallel", http.StatusOK, map[string]int{"n": i}, "JSON", nil)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	served := map[int]int{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := http.Post(server.URLFor("/parallel"), "text/plain", strings.NewReader(strconv.Itoa(i)))
+			if err != nil {
+				t.Errorf("failed to call mock: %v", err)
+				return
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+			var body map[string]int
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Errorf("unexpected response with status %d: %v", resp.StatusCode, err)
+				return
+			}
+			lock.Lock()
+			served[body["n"]]++
+			lock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, served, n)
+	for i := 0; i < n; i++ {
+		assert.Equalf(t, 1, served[i], "interaction %d", i)
+	}
+	assert.Equal(t, n, server.Interactions.CallCount(http.MethodPost, "/parallel"))
+	assert.Empty(t, server.Interactions.Unconsumed())
+
+	captured := map[string]bool{}
+	for _, rr := range server.Interactions.AllInteractions(http.MethodPost, "/parallel") {
+		captured[string(rr.CapturedRequestBody)] = true
+	}
+	assert.Len(t, captured, n)
 }
 
 func TestMockServer_CaptureFunc(t *testing.T) {
@@ -166,3 +271,2394 @@ func TestMockServer_CaptureFunc(t *testing.T) {
 
 	assert.Equal(t, times, counter)
 }
+
+func TestMockServer_RequestBodyMatching(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/orders", s.Port)
+
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusOK, nil, "JSON", nil)
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil, option.WithRequestBody([]byte("A")))
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusAccepted, nil, "JSON", nil, option.WithRequestBody([]byte("B")))
+
+	for _, tc := range []struct {
+		body           string
+		expectedStatus int
+	}{
+		{body: "B", expectedStatus: http.StatusAccepted},
+		{body: "C", expectedStatus: http.StatusOK},
+		{body: "A", expectedStatus: http.StatusCreated},
+		{body: "A", expectedStatus: http.StatusNotImplemented},
+	} {
+		resp, err := http.Post(uri, "text/plain", strings.NewReader(tc.body))
+		assert.NoError(t, err)
+		assert.Equalf(t, tc.expectedStatus, resp.StatusCode, "body: %v", tc.body)
+	}
+}
+
+func TestMockServer_CloseDelimitedResponse(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/legacy", s.Port)
+	response := map[string]string{"foo": "bar"}
+
+	s.AddInteraction(http.MethodGet, "/legacy", http.StatusOK, response, "JSON", nil, option.WithCloseDelimitedResponse())
+
+	resp, err := http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.True(t, resp.Close)
+
+	actualBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.NoError(t, err)
+
+	expectedBody, _ := jsoniter.Marshal(response)
+	assert.Equal(t, expectedBody, actualBody)
+}
+
+func TestMockServer_NotifyOn(t *testing.T) {
+	s := StartDefaultHttpServer()
+	notifications := make(chan CapturedRequest, 1)
+	s.NotifyOn(notifications, option.ForRequest(http.MethodPost, "/events"))
+
+	s.AddInteraction(http.MethodPost, "/events", http.StatusAccepted, nil, "JSON", nil)
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/events", s.Port), "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	select {
+	case captured := <-notifications:
+		assert.Equal(t, http.MethodPost, captured.Method)
+		assert.Equal(t, "/events", captured.Path)
+		assert.Equal(t, []byte("payload"), captured.Body)
+		assert.True(t, captured.Matched)
+	case <-time.After(time.Second):
+		t.Fatal("no notification received")
+	}
+}
+
+func TestMockServer_ServerTiming(t *testing.T) {
+	s := StartDefaultHttpServer()
+	metrics := map[string]time.Duration{"db": 53 * time.Millisecond, "app": 1500 * time.Microsecond}
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithServerTiming(metrics))
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d", s.Port))
+	assert.NoError(t, err)
+	assert.Equal(t, "app;dur=1.5, db;dur=53", resp.Header.Get("Server-Timing"))
+}
+
+func TestMockServer_MaxHeaderBytes(t *testing.T) {
+	s := NewServer().
+		WithConfig(defaultConfig).
+		WithLogger(zap.L()).
+		WithMaxHeaderBytes(1024).
+		Start()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	req, _ := http.NewRequest(http.MethodGet, uri, nil)
+	req.Header.Set("X-Bloated", strings.Repeat("a", 2048))
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+
+	resp, err = http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_ExpectedCalls(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithExpectedCalls(2))
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	shutdownCalled := false
+	s.OnShutdown(func() { shutdownCalled = true })
+	s.Shutdown()
+
+	errs := s.ExpectationErrors()
+	assert.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], "expected 2 calls for key GET_/ but got 1")
+	assert.True(t, shutdownCalled)
+}
+
+func TestMockServer_ServeDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "app.css"), []byte("body {}"), 0o600))
+
+	s := StartDefaultHttpServer().ServeDir("/assets", dir)
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+
+	resp, err := http.Get(uri + "/assets/app.css")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "body {}", string(actualBody))
+
+	resp, err = http.Get(uri + "/assets/missing.css")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(uri + "/assets/%2e%2e/%2e%2e/etc/passwd")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestMockServer_ResponseChecksum(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/upload", http.StatusOK, map[string]string{"status": "stored"}, "JSON", nil, option.WithResponseChecksum("sha256", option.ChecksumSHA256))
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/upload", s.Port), "text/plain", strings.NewReader("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var actual map[string]string
+	assert.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(&actual))
+	_ = resp.Body.Close()
+	assert.Equal(t, map[string]string{
+		"status": "stored",
+		"sha256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}, actual)
+}
+
+func TestMockServer_ExpectContinue(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/upload", s.Port)
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: time.Second}}
+
+	s.AddInteraction(http.MethodPut, "/upload", http.StatusOK, nil, "JSON", nil, option.WithExpectContinue(option.ExpectContinueReject))
+	s.AddInteraction(http.MethodPut, "/upload", http.StatusCreated, nil, "JSON", nil)
+
+	for _, expectedStatus := range []int{http.StatusExpectationFailed, http.StatusCreated} {
+		req, _ := http.NewRequest(http.MethodPut, uri, strings.NewReader("large upload"))
+		req.Header.Set("Expect", "100-continue")
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+	}
+}
+
+func TestMockServer_ExpectContinueMatchers(t *testing.T) {
+	recorder := NewRecorder()
+	var requests, matches int
+	s := NewServer().WithConfig(defaultConfig).WithLogger(zap.L()).WithSharedRecorder(recorder).
+		WithOnRequest(func(r *http.Request, body []byte) { requests++ }).
+		WithOnMatch(func(rr *RequestResponse) { matches++ }).
+		Start()
+	defer s.Shutdown()
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: time.Second}}
+
+	s.AddInteraction(http.MethodPut, "/upload", http.StatusOK, nil, "JSON", nil,
+		option.WithExpectContinue(option.ExpectContinueReject), option.WithRequestHeaders(http.Header{"X-Tenant": []string{"a"}}))
+	s.AddInteraction(http.MethodPut, "/upload", http.StatusCreated, nil, "JSON", nil)
+
+	for _, tt := range []struct {
+		tenant         string
+		expectedStatus int
+	}{
+		{tenant: "b", expectedStatus: http.StatusCreated},
+		{tenant: "a", expectedStatus: http.StatusExpectationFailed},
+	} {
+		req, _ := http.NewRequest(http.MethodPut, s.URLFor("/upload"), strings.NewReader("large upload"))
+		req.Header.Set("Expect", "100-continue")
+		req.Header.Set("X-Tenant", tt.tenant)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expectedStatus, resp.StatusCode, tt.tenant)
+	}
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 2, matches)
+	assert.Len(t, recorder.Sequence(), 2)
+	assert.Equal(t, float64(2), s.Interactions.RequestRate(http.MethodPut, "/upload"))
+}
+
+func TestMockServer_SharedRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	a := NewServer().WithConfig(defaultConfig).WithLogger(zap.L()).WithID("a").WithSharedRecorder(recorder).Start()
+	b := NewServer().WithConfig(defaultConfig).WithLogger(zap.L()).WithID("b").WithSharedRecorder(recorder).Start()
+
+	a.AddInteraction(http.MethodGet, "/first", http.StatusOK, nil, "JSON", nil)
+	b.AddInteraction(http.MethodPost, "/second", http.StatusOK, nil, "JSON", nil)
+	a.AddInteraction(http.MethodGet, "/third", http.StatusOK, nil, "JSON", nil)
+
+	_, _ = http.Get(fmt.Sprintf("http://localhost:%d/first", a.Port))
+	_, _ = http.Post(fmt.Sprintf("http://localhost:%d/second", b.Port), "text/plain", nil)
+	_, _ = http.Get(fmt.Sprintf("http://localhost:%d/third", a.Port))
+
+	sequence := recorder.Sequence()
+	assert.Len(t, sequence, 3)
+	for i, expected := range []RecordedCall{
+		{ServerID: "a", Method: http.MethodGet, Path: "/first"},
+		{ServerID: "b", Method: http.MethodPost, Path: "/second"},
+		{ServerID: "a", Method: http.MethodGet, Path: "/third"},
+	} {
+		assert.Equal(t, expected.ServerID, sequence[i].ServerID)
+		assert.Equal(t, expected.Method, sequence[i].Method)
+		assert.Equal(t, expected.Path, sequence[i].Path)
+	}
+}
+
+func TestMockServer_RangeRequests(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/download", s.Port)
+	response := map[string]string{"foo": "bar"} // {"foo":"bar"}
+
+	tests := []struct {
+		rangeHeader          string
+		expectedStatus       int
+		expectedBody         string
+		expectedContentRange string
+	}{
+		{rangeHeader: "bytes=0-5", expectedStatus: http.StatusPartialContent, expectedBody: `{"foo"`, expectedContentRange: "bytes 0-5/13"},
+		{rangeHeader: "bytes=7-", expectedStatus: http.StatusPartialContent, expectedBody: `"bar"}`, expectedContentRange: "bytes 7-12/13"},
+		{rangeHeader: "bytes=-1", expectedStatus: http.StatusPartialContent, expectedBody: `}`, expectedContentRange: "bytes 12-12/13"},
+		{rangeHeader: "bytes=20-30", expectedStatus: http.StatusRequestedRangeNotSatisfiable, expectedContentRange: "bytes */13"},
+	}
+	for _, tt := range tests {
+		s.AddInteraction(http.MethodGet, "/download", http.StatusOK, response, "JSON", nil, option.WithRangeRequests())
+
+		req, _ := http.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("Range", tt.rangeHeader)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expectedStatus, resp.StatusCode, tt.rangeHeader)
+		assert.Equal(t, tt.expectedContentRange, resp.Header.Get("Content-Range"), tt.rangeHeader)
+
+		actualBody, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, tt.expectedBody, string(actualBody), tt.rangeHeader)
+	}
+}
+
+func TestMockServer_KeyConcurrencyLimit(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/heavy", s.Port)
+	requests := 4
+
+	for i := 0; i < 3; i++ {
+		s.AddInteraction(http.MethodGet, "/heavy", http.StatusOK, nil, "JSON", nil,
+			option.WithKeyConcurrencyLimit(2, option.OverflowReject), option.WithResponseDelay(300*time.Millisecond))
+	}
+
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	wg.Add(requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(uri)
+			if assert.NoError(t, err) {
+				statuses <- resp.StatusCode
+			}
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(t, map[int]int{http.StatusOK: 2, http.StatusServiceUnavailable: 2}, counts)
+	assert.Equal(t, 2, s.Interactions.CallCount(http.MethodGet, "/heavy"))
+	matched := 0
+	for _, logged := range s.RequestLog() {
+		if logged.Matched {
+			matched++
+		}
+	}
+	assert.Equal(t, 2, matched)
+
+	resp, err := http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_CaptureNormalizer(t *testing.T) {
+	var captured []byte
+	s := NewServer().
+		WithConfig(defaultConfig).
+		WithLogger(zap.L()).
+		WithCaptureNormalizer(func(body []byte) []byte { return []byte(strings.ToUpper(string(body))) }).
+		Start()
+	s.AddInteraction(http.MethodPost, "/", http.StatusOK, nil, "JSON", func(body []byte, headers http.Header) {
+		captured = body
+	})
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d", s.Port), "text/plain", strings.NewReader("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []byte("PAYLOAD"), captured)
+}
+
+func TestMockServer_QueryParamsMatching(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/search", s.Port)
+
+	s.AddInteraction(http.MethodGet, "/search", http.StatusOK, map[string]string{"q": "foo"}, "JSON", nil, option.WithQueryParams(map[string]string{"q": "foo"}))
+	s.AddInteraction(http.MethodGet, "/search", http.StatusOK, map[string]string{"q": "bar"}, "JSON", nil, option.WithQueryParams(map[string]string{"q": "bar"}))
+
+	for _, q := range []string{"bar", "foo"} {
+		resp, err := http.Get(uri + "?q=" + q)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		actualBody, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.JSONEq(t, `{"q":"`+q+`"}`, string(actualBody))
+	}
+
+	resp, err := http.Get(uri + "?q=baz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestMockServer_BodyMatcher(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/orders", s.Port)
+	express := option.WithBodyMatcher(func(body []byte) bool { return strings.Contains(string(body), "express") })
+
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusOK, nil, "JSON", nil)
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil, express)
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil, express)
+
+	for _, tt := range []struct {
+		body           string
+		expectedStatus int
+	}{
+		{body: `{"shipping":"express"}`, expectedStatus: http.StatusCreated},
+		{body: `{"shipping":"standard"}`, expectedStatus: http.StatusOK},
+		{body: `{"shipping":"standard"}`, expectedStatus: http.StatusNotImplemented},
+		{body: `{"shipping":"express"}`, expectedStatus: http.StatusCreated},
+	} {
+		resp, err := http.Post(uri, "application/json", strings.NewReader(tt.body))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expectedStatus, resp.StatusCode, tt.body)
+	}
+}
+
+func TestMockServer_RequestHeadersMatching(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/me", s.Port)
+
+	s.AddInteraction(http.MethodGet, "/me", http.StatusOK, nil, "JSON", nil, option.WithRequestHeaders(http.Header{"authorization": []string{"Bearer A"}}))
+	s.AddInteraction(http.MethodGet, "/me", http.StatusForbidden, nil, "JSON", nil, option.WithRequestHeaders(http.Header{"Authorization": []string{"Bearer B"}}))
+
+	for token, expectedStatus := range map[string]int{"Bearer B": http.StatusForbidden, "Bearer A": http.StatusOK, "Bearer C": http.StatusNotImplemented} {
+		req, _ := http.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedStatus, resp.StatusCode, token)
+	}
+}
+
+func TestMockServer_PathRegex(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+
+	s.AddInteraction(http.MethodGet, "/users/{id}", http.StatusOK, nil, "JSON", nil, option.WithPathRegex(`^/users/\d+$`))
+	s.AddInteraction(http.MethodGet, "/users/{id}", http.StatusOK, nil, "JSON", nil, option.WithPathRegex(`^/users/\d+$`))
+
+	for _, path := range []string{"/users/123", "/users/456"} {
+		resp, err := http.Get(uri + path)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+
+	resp, err := http.Get(uri + "/users/abc")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	assert.Panics(t, func() {
+		s.AddInteraction(http.MethodGet, "/invalid", http.StatusOK, nil, "JSON", nil, option.WithPathRegex(`(`))
+	})
+}
+
+func TestMockServer_PathTemplate(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+	var params map[string]string
+
+	s.AddInteraction(http.MethodGet, "/users/:id/orders/:orderId", http.StatusOK, nil, "JSON", nil,
+		option.WithCaptureFuncV2(func(body []byte, headers http.Header, pathParams map[string]string) {
+			params = pathParams
+		}))
+	s.AddInteraction(http.MethodGet, "/users/7/orders/1", http.StatusAccepted, nil, "JSON", nil)
+
+	resp, err := http.Get(uri + "/users/7/orders/1")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Nil(t, params)
+
+	resp, err = http.Get(uri + "/users/7/orders/99")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{"id": "7", "orderId": "99"}, params)
+}
+
+func TestMockServer_PathTemplateWithLiterals(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	var params map[string]string
+	capture := option.WithCaptureFuncV2(func(body []byte, headers http.Header, pathParams map[string]string) {
+		params = pathParams
+	})
+	s.AddInteraction(http.MethodGet, "/files/:name.json", http.StatusOK, nil, "JSON", nil, capture)
+	s.AddInteraction(http.MethodGet, "/releases/:major-:minor", http.StatusOK, nil, "JSON", nil, capture)
+
+	resp, err := http.Get(s.URLFor("/files/report.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{"name": "report"}, params)
+
+	resp, err = http.Get(s.URLFor("/files/report.xml"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	resp, err = http.Get(s.URLFor("/releases/1-2"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{"major": "1", "minor": "2"}, params)
+}
+
+func TestMockServer_PathParamsConcurrentRequests(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	var lock sync.Mutex
+	captured := map[string]string{}
+	s.AddInteraction(http.MethodGet, "/users/:id", http.StatusOK, nil, "JSON", nil,
+		option.WithPersistent(), option.WithResponseDelay(200*time.Millisecond), option.WithResponseTemplate(`{{.PathParams.id}}`),
+		option.WithCaptureFuncV2(func(body []byte, headers http.Header, pathParams map[string]string) {
+			lock.Lock()
+			defer lock.Unlock()
+			captured[headers.Get("X-Id")] = pathParams["id"]
+		}))
+
+	ids := []string{"1", "2", "3", "4", "5"}
+	var wg sync.WaitGroup
+	wg.Add(len(ids))
+	for _, id := range ids {
+		go func(id string) {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, s.URLFor("/users/"+id), nil)
+			req.Header.Set("X-Id", id)
+			resp, err := http.DefaultClient.Do(req)
+			if assert.NoError(t, err) {
+				body, _ := ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				assert.Equal(t, id, string(body))
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(t, id, captured[id])
+	}
+}
+
+func TestMockServer_RawResponseBody(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithRawResponseBody([]byte(`{"broken":`)))
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d", s.Port))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"broken":`, string(actualBody))
+}
+
+func TestMockServer_ResponseBodyFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fixture.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"foo":"bar"}`), 0o600))
+
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithResponseBodyFile(file))
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithResponseBodyFile(file+".missing"))
+
+	resp, err := http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"foo":"bar"}`, string(actualBody))
+
+	resp, err = http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestMockServer_ResponseBodyFileRange(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "download.bin")
+	assert.NoError(t, os.WriteFile(file, []byte("0123456789"), 0o600))
+
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/download", http.StatusOK, nil, "application/octet-stream", nil,
+		option.WithResponseBodyFile(file), option.WithPersistent())
+
+	resp, err := http.Get(s.URLFor("/download"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "0123456789", string(body))
+
+	req, _ := http.NewRequest(http.MethodGet, s.URLFor("/download"), nil)
+	req.Header.Set("Range", "bytes=4-")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "bytes 4-9/10", resp.Header.Get("Content-Range"))
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "456789", string(body))
+}
+
+func TestMockServer_Priority(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/users/1", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/users/*", http.StatusServiceUnavailable, nil, "JSON", nil,
+		option.WithPathRegex(`^/users/`), option.WithPriority(10), option.WithTimes(1))
+	s.AddInteraction(http.MethodGet, "/users/1", http.StatusAccepted, nil, "JSON", nil, option.WithPriority(5))
+	s.AddInteraction(http.MethodGet, "/users/1", http.StatusCreated, nil, "JSON", nil, option.WithPriority(5))
+
+	var statuses []int
+	for i := 0; i < 4; i++ {
+		resp, err := http.Get(s.URLFor("/users/1"))
+		assert.NoError(t, err)
+		statuses = append(statuses, resp.StatusCode)
+	}
+	assert.Equal(t, []int{http.StatusServiceUnavailable, http.StatusAccepted, http.StatusCreated, http.StatusOK}, statuses)
+}
+
+func TestMockServer_ResponseFunc(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/echo", http.StatusOK, nil, "JSON", nil,
+		option.WithResponseFunc(func(req *http.Request, body []byte) (int, interface{}, http.Header) {
+			return http.StatusCreated, map[string]string{"echo": string(body)}, http.Header{"X-Request-Path": []string{req.URL.Path}}
+		}))
+
+	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/echo", s.Port), "text/plain", strings.NewReader("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/echo", resp.Header.Get("X-Request-Path"))
+
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"echo":"hello"}`, string(actualBody))
+}
+
+func TestMockServer_CapturedRequestIsStored(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/orders", s.Port), strings.NewReader(`{"id":1}`))
+	req.Header.Set("X-Request-Id", "42")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	interaction := s.Interactions.Interaction(http.MethodPost, "/orders", 0)
+	assert.Equal(t, []byte(`{"id":1}`), interaction.CapturedRequestBody)
+	assert.Equal(t, "42", interaction.CapturedRequestHeaders.Get("X-Request-Id"))
+	assert.Equal(t, []byte(`{"id":1}`), s.Interactions.AllInteractions(http.MethodPost, "/orders")[0].CapturedRequestBody)
+}
+
+func TestMockServer_CapturedURL(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/search", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(s.URLFor("/search?q=a%20b&state=xyz&state=abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	interaction := s.Interactions.Interaction(http.MethodGet, "/search", 0)
+	assert.Equal(t, "/search?q=a%20b&state=xyz&state=abc", interaction.CapturedURL)
+	assert.Equal(t, []string{"xyz", "abc"}, interaction.CapturedQuery["state"])
+}
+
+func TestMockServer_StartWithoutLogger(t *testing.T) {
+	s := NewServer().WithConfig(defaultConfig).Start()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d", s.Port))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	s.Shutdown()
+}
+
+func TestMockServer_StartWithoutConfig(t *testing.T) {
+	s := NewServer().Start()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d", s.Port))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	s.Shutdown()
+}
+
+func TestMockServer_ContentTypes(t *testing.T) {
+	type item struct {
+		Name string `xml:"name" json:"name"`
+	}
+	tests := []struct {
+		contentType         string
+		responseObject      interface{}
+		expectedContentType string
+		expectedBody        string
+	}{
+		{contentType: "JSON", responseObject: item{Name: "foo"}, expectedContentType: "application/json; charset=utf-8", expectedBody: `{"name":"foo"}`},
+		{contentType: "XML", responseObject: item{Name: "foo"}, expectedContentType: "application/xml; charset=utf-8", expectedBody: `<item><name>foo</name></item>`},
+		{contentType: "xml", responseObject: item{Name: "foo"}, expectedContentType: "application/xml; charset=utf-8", expectedBody: `<item><name>foo</name></item>`},
+		{contentType: "application/xml", responseObject: item{Name: "foo"}, expectedContentType: "application/xml", expectedBody: `<item><name>foo</name></item>`},
+		{contentType: "json", responseObject: item{Name: "foo"}, expectedContentType: "application/json; charset=utf-8", expectedBody: `{"name":"foo"}`},
+		{contentType: "application/problem+json", responseObject: item{Name: "foo"}, expectedContentType: "application/problem+json", expectedBody: `{"name":"foo"}`},
+		{contentType: "text/plain", responseObject: "plain text", expectedContentType: "text/plain", expectedBody: "plain text"},
+		{contentType: "text/html; charset=utf-8", responseObject: "<p>hi</p>", expectedContentType: "text/html; charset=utf-8", expectedBody: "<p>hi</p>"},
+		{contentType: "application/yaml", responseObject: "name: foo\n", expectedContentType: "application/yaml", expectedBody: "name: foo\n"},
+	}
+
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+	for _, tt := range tests {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, tt.responseObject, tt.contentType, nil)
+
+		resp, err := http.Get(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expectedContentType, resp.Header.Get("Content-Type"), tt.contentType)
+		actualBody, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, tt.expectedBody, string(actualBody), tt.contentType)
+	}
+}
+
+func TestMockServer_SetDefault(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.SetDefault(http.MethodGet, http.StatusNotFound, map[string]string{"error": "unknown route"})
+	s.SetDefault(http.MethodDelete, http.StatusNoContent, nil)
+	s.AddInteraction(http.MethodGet, "/known", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(s.URLFor("/known"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(s.URLFor("/unknown"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"error":"unknown route"}`, string(body))
+
+	req, _ := http.NewRequest(http.MethodDelete, s.URLFor("/unknown"), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(s.URLFor("/unknown"), "text/plain", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	assert.Len(t, s.UnmatchedRequests(), 3)
+}
+
+func TestMockServer_SortedMapKeys(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	object := map[string]interface{}{"f": 6, "b": 2, "e": "<5>", "a": 1, "d": 4, "c": 3}
+	for i := 0; i < 10; i++ {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, object, "JSON", nil)
+	}
+
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get(s.URL())
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, `{"a":1,"b":2,"c":3,"d":4,"e":"\u003c5\u003e","f":6}`, string(body))
+	}
+}
+
+func TestMockServer_WithMarshaller(t *testing.T) {
+	s := StartDefaultHttpServer().WithMarshaller(func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(v, "", "  ")
+	}).WithNotFoundResponse(http.StatusNotFound, map[string]string{"error": "not found"})
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, map[string]string{"name": "foo"}, "JSON", nil)
+
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "{\n  \"name\": \"foo\"\n}", string(body))
+
+	resp, err = http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "{\n  \"error\": \"not found\"\n}", string(body))
+}
+
+func TestMockServer_NoBodyStatuses(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	s := NewServer().WithLogger(zap.New(core)).Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodDelete, "/items/1", http.StatusNoContent, map[string]string{"name": "foo"}, "JSON", nil,
+		option.WithResponseHeaders(http.Header{"X-Deleted": {"1"}}))
+	s.AddInteraction(http.MethodGet, "/items/1", http.StatusNotModified, map[string]string{"name": "foo"}, "JSON", nil)
+
+	req, _ := http.NewRequest(http.MethodDelete, s.URLFor("/items/1"), nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("X-Deleted"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Empty(t, body)
+
+	resp, err = http.Get(s.URLFor("/items/1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Empty(t, body)
+
+	assert.Equal(t, 2, logs.FilterMessage("dropping response body since the status does not allow one").Len())
+}
+
+func TestMockServer_ResponseReader(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	calls := 0
+	s.AddInteraction(http.MethodGet, "/sized", http.StatusOK, nil, "text/plain", nil, option.WithPersistent(),
+		option.WithResponseReader(func() io.Reader {
+			calls++
+			return strings.NewReader(fmt.Sprintf("call %d", calls))
+		}))
+	s.AddInteraction(http.MethodGet, "/chunked", http.StatusOK, nil, "text/plain", nil,
+		option.WithResponseReader(func() io.Reader {
+			return io.MultiReader(strings.NewReader(strings.Repeat("a", 8192)), strings.NewReader("b"))
+		}))
+
+	for _, expected := range []string{"call 1", "call 2"} {
+		resp, err := http.Get(s.URLFor("/sized"))
+		assert.NoError(t, err)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.Equal(t, int64(len(expected)), resp.ContentLength)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, expected, string(body))
+	}
+
+	resp, err := http.Get(s.URLFor("/chunked"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, strings.Repeat("a", 8192)+"b", string(body))
+}
+
+func TestMockServer_NonstandardStatusCodes(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	for _, status := range []int{http.StatusTeapot, 299, 599, 999} {
+		s.AddInteraction(http.MethodGet, "/body", status, map[string]int{"status": status}, "JSON", nil)
+		s.AddInteraction(http.MethodGet, "/empty", status, nil, "JSON", nil)
+
+		resp, err := http.Get(s.URLFor("/body"))
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, fmt.Sprintf(`{"status":%d}`, status), string(body))
+
+		resp, err = http.Get(s.URLFor("/empty"))
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode)
+	}
+
+	s.AddInteraction(http.MethodGet, "/reason", 599, map[string]string{"error": "timeout"}, "JSON", nil,
+		option.WithReasonPhrase("Network Connect Timeout Error"))
+	resp, err := http.Get(s.URLFor("/reason"))
+	assert.NoError(t, err)
+	assert.Equal(t, 599, resp.StatusCode)
+	assert.Equal(t, "599 Network Connect Timeout Error", resp.Status)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"error":"timeout"}`, string(body))
+}
+
+func TestMockServer_FormResponse(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodPost, "/oauth/token", http.StatusOK, nil, "JSON", nil,
+		option.WithFormResponse(url.Values{"access_token": {"abc 123"}, "token_type": {"bearer"}}))
+
+	resp, err := http.PostForm(s.URLFor("/oauth/token"), url.Values{"grant_type": {"client_credentials"}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-www-form-urlencoded", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "access_token=abc+123&token_type=bearer", string(body))
+
+	values, err := url.ParseQuery(string(body))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc 123", values.Get("access_token"))
+}
+
+func TestMockServer_Times(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d", s.Port)
+
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithTimes(3))
+	s.AddInteraction(http.MethodGet, "/", http.StatusAccepted, nil, "JSON", nil)
+
+	for _, expectedStatus := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusAccepted, http.StatusNotImplemented} {
+		resp, err := http.Get(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+	}
+	assert.Equal(t, 4, s.Interactions.CallCount(http.MethodGet, "/"))
+	assert.Equal(t, 0, s.Interactions.CallCount(http.MethodPost, "/"))
+}
+
+func TestMockServer_Remaining(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithTimes(2))
+	s.AddInteraction(http.MethodGet, "/", http.StatusAccepted, nil, "JSON", nil)
+	s.AddInteraction(http.MethodGet, "/", http.StatusNoContent, nil, "JSON", nil, option.WithPersistent())
+
+	assert.Equal(t, 3, s.Interactions.Remaining(http.MethodGet, "/"))
+	for _, remaining := range []int{2, 1, 0, 0} {
+		_, err := http.Get(s.URL())
+		assert.NoError(t, err)
+		assert.Equal(t, remaining, s.Interactions.Remaining(http.MethodGet, "/"))
+	}
+	assert.Equal(t, 0, s.Interactions.Remaining(http.MethodPost, "/"))
+}
+
+func TestMockServer_Find(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil, option.WithResponseDelay(time.Millisecond))
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+	s.AddInteraction(http.MethodGet, "/orders", http.StatusOK, nil, "JSON", nil, option.WithResponseDelay(time.Millisecond))
+
+	_, err := http.Post(s.URLFor("/orders"), "text/plain", strings.NewReader("order"))
+	assert.NoError(t, err)
+
+	delayed := s.Interactions.Find(func(rr RequestResponse) bool { return rr.DelayResponse > 0 })
+	assert.Len(t, delayed, 2)
+	assert.Equal(t, http.MethodGet, delayed[0].Method)
+	assert.Equal(t, http.MethodPost, delayed[1].Method)
+
+	captured := s.Interactions.Find(func(rr RequestResponse) bool { return len(rr.CapturedRequestBody) > 0 })
+	assert.Len(t, captured, 1)
+	assert.Equal(t, []byte("order"), captured[0].CapturedRequestBody)
+
+	assert.Empty(t, s.Interactions.Find(func(rr RequestResponse) bool { return rr.Method == http.MethodPut }))
+}
+
+func TestMockServer_Persistent(t *testing.T) {
+	s := StartDefaultHttpServer()
+	uri := fmt.Sprintf("http://localhost:%d/status", s.Port)
+
+	s.AddInteraction(http.MethodGet, "/status", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/status", http.StatusServiceUnavailable, nil, "JSON", nil, option.WithTimes(2))
+
+	for _, expectedStatus := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK, http.StatusOK, http.StatusOK} {
+		resp, err := http.Get(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+	}
+
+	s.Reset()
+	resp, err := http.Get(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestMockServer_URL(t *testing.T) {
+	s := StartDefaultHttpServer()
+	assert.Equal(t, fmt.Sprintf("http://127.0.0.1:%d", s.Port), s.URL())
+	assert.Equal(t, fmt.Sprintf("http://127.0.0.1:%d/orders", s.Port), s.URLFor("/orders"))
+	assert.Equal(t, fmt.Sprintf("http://127.0.0.1:%d/orders", s.Port), s.URLFor("orders"))
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", s.Port), s.ID())
+
+	s.AddInteraction(http.MethodGet, "/orders", http.StatusOK, nil, "JSON", nil)
+	resp, err := http.Get(s.URLFor("/orders"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_StartTLS(t *testing.T) {
+	s := NewServer().StartTLS()
+	s.AddInteraction(http.MethodGet, "/secure", http.StatusOK, nil, "JSON", nil)
+	assert.True(t, strings.HasPrefix(s.URL(), "https://"))
+
+	resp, err := http.Get(s.URLFor("/secure"))
+	assert.Error(t, err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: s.CertPool()}}}
+	resp, err = client.Get(s.URLFor("/secure"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	s.Shutdown()
+}
+
+func TestMockServer_WithPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, listener.Close())
+	s := NewServer().WithPort(port).Start()
+	assert.Equal(t, port, s.Port)
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d", port))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Panics(t, func() { NewServer().WithPort(port).Start() })
+	s.Shutdown()
+}
+
+func TestMockServer_StartE(t *testing.T) {
+	s, err := NewServer().StartE()
+	assert.NoError(t, err)
+
+	_, err = NewServer().WithPort(s.Port).StartE()
+	assert.Error(t, err)
+	s.Shutdown()
+}
+
+func TestMockServer_ShutdownE(t *testing.T) {
+	s := NewServer().Start()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, s.ShutdownE(ctx))
+	_, err := http.Get(s.URL())
+	assert.Error(t, err)
+}
+
+func TestMockServer_Unconsumed(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/orders", http.StatusOK, nil, "JSON", nil)
+	s.AddInteraction(http.MethodGet, "/orders", http.StatusAccepted, nil, "JSON", nil)
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+	s.AddInteraction(http.MethodGet, "/status", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+
+	_, _ = http.Get(s.URLFor("/orders"))
+
+	unconsumed := s.Interactions.Unconsumed()
+	assert.Len(t, unconsumed, 2)
+	assert.Equal(t, http.StatusAccepted, unconsumed[0].ResponseHttpStatus)
+	assert.Equal(t, http.MethodPost, unconsumed[1].Method)
+}
+
+func TestMockServer_UnmatchedRequests(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+
+	resp, err := http.Post(s.URLFor("/v2/orders"), "application/json", strings.NewReader(`{"id":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	unmatched := s.UnmatchedRequests()
+	assert.Len(t, unmatched, 1)
+	assert.Equal(t, http.MethodPost, unmatched[0].Method)
+	assert.Equal(t, "/v2/orders", unmatched[0].Path)
+	assert.Equal(t, []byte(`{"id":1}`), unmatched[0].CapturedRequestBody)
+	assert.Equal(t, "application/json", unmatched[0].CapturedRequestHeaders.Get("Content-Type"))
+
+	s.Reset()
+	assert.Empty(t, s.UnmatchedRequests())
+}
+
+func TestMockServer_LoadFromFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "interactions.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`[
+		{"method": "GET", "path": "/orders", "status": 200, "body": {"id": 1}, "headers": {"X-Total": ["1"]}, "comment": "ignored"},
+		{"method": "DELETE", "path": "/orders/1", "status": 204}
+	]`), 0o600))
+
+	s := StartDefaultHttpServer()
+	assert.NoError(t, s.LoadFromFile(file))
+
+	resp, err := http.Get(s.URLFor("/orders"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("X-Total"))
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"id":1}`, string(actualBody))
+
+	req, _ := http.NewRequest(http.MethodDelete, s.URLFor("/orders/1"), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	malformed := filepath.Join(dir, "malformed.json")
+	assert.NoError(t, os.WriteFile(malformed, []byte(`[{"method": "GET",`), 0o600))
+	assert.Error(t, s.LoadFromFile(malformed))
+	assert.Error(t, s.LoadFromFile(filepath.Join(dir, "missing.json")))
+}
+
+func TestMockServer_Export(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, map[string]int{"id": 1}, "JSON", nil, option.WithResponseDelay(10*time.Millisecond))
+
+	resp, err := http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"item":"book"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	exported, err := s.Interactions.Export()
+	assert.NoError(t, err)
+	var specs []InteractionSpec
+	assert.NoError(t, jsoniter.Unmarshal(exported, &specs))
+	assert.Len(t, specs, 1)
+	assert.Equal(t, int64(10), specs[0].DelayMs)
+	assert.Equal(t, `{"item":"book"}`, specs[0].Captured.Body)
+	assert.Equal(t, "application/json", specs[0].Captured.Headers.Get("Content-Type"))
+
+	file := filepath.Join(t.TempDir(), "exported.json")
+	assert.NoError(t, os.WriteFile(file, exported, 0o600))
+	replay := StartDefaultHttpServer()
+	assert.NoError(t, replay.LoadFromFile(file))
+
+	resp, err = http.Post(replay.URLFor("/orders"), "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"id":1}`, string(actualBody))
+}
+
+func TestMockServer_Upstream(t *testing.T) {
+	upstream := StartDefaultHttpServer()
+	upstream.AddInteraction(http.MethodGet, "/api/orders", http.StatusOK, map[string]int{"id": 1}, "JSON", nil,
+		option.WithResponseHeaders(http.Header{"X-Upstream": []string{"yes"}}))
+
+	s := NewServer().WithUpstream(upstream.URLFor("/api")).Start()
+	resp, err := http.Get(s.URLFor("/orders"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	actualBody, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"id":1}`, string(actualBody))
+
+	recorded := s.Interactions.AllInteractions(http.MethodGet, "/orders")
+	assert.Len(t, recorded, 1)
+	assert.Equal(t, http.StatusOK, recorded[0].ResponseHttpStatus)
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, recorded[0].ResponseObject)
+	assert.Empty(t, s.Interactions.Unconsumed())
+	s.Shutdown()
+}
+
+func TestMockServer_UpstreamGzip(t *testing.T) {
+	upstream := StartDefaultHttpServer()
+	defer upstream.Shutdown()
+	upstream.AddInteraction(http.MethodGet, "/orders", http.StatusOK, map[string]int{"id": 1}, "JSON", nil, option.WithGzipResponse())
+
+	s := NewServer().WithUpstream(upstream.URL()).Start()
+	defer s.Shutdown()
+	req, _ := http.NewRequest(http.MethodGet, s.URLFor("/orders"), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"id":1}`, string(body))
+
+	recorded := s.Interactions.AllInteractions(http.MethodGet, "/orders")
+	if assert.Len(t, recorded, 1) {
+		assert.Equal(t, map[string]interface{}{"id": float64(1)}, recorded[0].ResponseObject)
+		assert.Empty(t, recorded[0].ResponseHeaders.Get("Content-Encoding"))
+	}
+}
+
+func TestMockServer_UpstreamRedirect(t *testing.T) {
+	upstream := StartDefaultHttpServer()
+	defer upstream.Shutdown()
+	upstream.AddInteraction(http.MethodGet, "/old", http.StatusFound, nil, "JSON", nil, option.WithRedirect("/final"))
+	upstream.AddInteraction(http.MethodGet, "/final", http.StatusOK, map[string]string{"final": "body"}, "JSON", nil)
+
+	s := NewServer().WithUpstream(upstream.URL()).Start()
+	defer s.Shutdown()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(s.URLFor("/old"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/final", resp.Header.Get("Location"))
+
+	recorded := s.Interactions.AllInteractions(http.MethodGet, "/old")
+	if assert.Len(t, recorded, 1) {
+		assert.Equal(t, http.StatusFound, recorded[0].ResponseHttpStatus)
+		assert.Equal(t, "/final", recorded[0].ResponseHeaders.Get("Location"))
+	}
+	assert.Equal(t, 0, upstream.Interactions.CallCount(http.MethodGet, "/final"))
+}
+
+func TestMockServer_ResponseCookies(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/login", http.StatusNoContent, nil, "JSON", nil, option.WithResponseCookies([]*http.Cookie{
+		{Name: "session", Value: "abc", Path: "/", HttpOnly: true, MaxAge: 3600},
+		{Name: "theme", Value: "dark"},
+	}))
+
+	resp, err := http.Post(s.URLFor("/login"), "text/plain", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, []string{"session=abc; Path=/; Max-Age=3600; HttpOnly", "theme=dark"}, resp.Header.Values("Set-Cookie"))
+}
+
+func TestMockServer_Redirect(t *testing.T) {
+	s := StartDefaultHttpServer()
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for _, status := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect} {
+		s.AddInteraction(http.MethodGet, "/old", status, map[string]string{"ignored": "body"}, "JSON", nil, option.WithRedirect("/new"))
+
+		resp, err := client.Get(s.URLFor("/old"))
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode)
+		assert.Equal(t, "/new", resp.Header.Get("Location"))
+		actualBody, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Empty(t, actualBody)
+	}
+
+	s.AddInteraction(http.MethodGet, "/old", http.StatusFound, nil, "JSON", nil, option.WithRedirect("/new"))
+	s.AddInteraction(http.MethodGet, "/new", http.StatusOK, nil, "JSON", nil)
+	resp, err := http.Get(s.URLFor("/old"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_DelayCancelledByClient(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	captured := make(chan struct{}, 1)
+	s.AddInteraction(http.MethodGet, "/slow", http.StatusOK, nil, "JSON", func([]byte, http.Header) { captured <- struct{}{} },
+		option.WithResponseDelay(5*time.Second))
+
+	client := http.Client{Timeout: 100 * time.Millisecond}
+	_, err := client.Get(s.URLFor("/slow"))
+	assert.Error(t, err)
+
+	mock := s.Interactions.Interaction(http.MethodGet, "/slow", 0)
+	assert.Eventually(t, func() bool { return s.Interactions.snapshot(mock).Elapsed > 0 }, time.Second, 10*time.Millisecond)
+	assert.Less(t, int64(s.Interactions.snapshot(mock).Elapsed), int64(time.Second))
+	assert.Empty(t, captured)
+}
+
+func TestMockServer_RandomDelay(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithRandomDelay(50*time.Millisecond, 100*time.Millisecond))
+
+	start := time.Now()
+	resp, err := http.Get(s.URL())
+	elapsed := time.Since(start)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.GreaterOrEqual(t, elapsed, 50*time.Millisecond)
+
+	assert.Panics(t, func() {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithRandomDelay(time.Second, time.Millisecond))
+	})
+}
+
+func TestMockServer_InvalidDelay(t *testing.T) {
+	var opts option.HttpMockOptions
+	assert.EqualError(t, option.WithResponseDelay(-time.Second)(&opts), "invalid negative response delay -1s")
+	assert.Error(t, option.WithRandomDelay(-time.Second, time.Second)(&opts))
+	assert.Error(t, option.WithRandomDelay(time.Second, time.Millisecond)(&opts))
+	assert.NoError(t, option.WithResponseDelay(0)(&opts))
+
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	assert.Panics(t, func() {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithResponseDelay(-time.Millisecond))
+	})
+	assert.Equal(t, 0, s.Interactions.Remaining(http.MethodGet, "/"))
+}
+
+func TestMockServer_Fault(t *testing.T) {
+	s := StartDefaultHttpServer()
+	for _, fault := range []option.FaultType{option.FaultConnectionReset, option.FaultEmptyResponse} {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithFault(fault))
+
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		_, err := client.Get(s.URL())
+		assert.Error(t, err)
+	}
+}
+
+func TestMockServer_RateLimit(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithRateLimit(2, 1500*time.Millisecond))
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(s.URL())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	s.Reset()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithRateLimit(1, time.Second))
+	resp, err = http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	s.Reset()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithRateLimit(0, 0))
+	for i := 0; i < 3; i++ {
+		resp, err = http.Get(s.URL())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "0", resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestMockServer_DegradeAboveRate(t *testing.T) {
+	s := StartDefaultHttpServer()
+	matches := 0
+	s.WithOnMatch(func(rr *RequestResponse) { matches++ })
+	for i := 0; i < 5; i++ {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithDegradeAboveRate(2))
+	}
+
+	for _, expectedStatus := range []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable, http.StatusServiceUnavailable} {
+		resp, err := http.Get(s.URL())
+		assert.NoError(t, err)
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+	}
+	assert.Equal(t, float64(4), s.Interactions.RequestRate(http.MethodGet, "/"))
+	assert.Equal(t, 2, s.Interactions.CallCount(http.MethodGet, "/"))
+	assert.Equal(t, 2, matches)
+	var matched []bool
+	for _, logged := range s.RequestLog() {
+		matched = append(matched, logged.Matched)
+	}
+	assert.Equal(t, []bool{true, true, false, false}, matched)
+
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, float64(0), s.Interactions.RequestRate(http.MethodGet, "/"))
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(s.URL())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 4, s.Interactions.CallCount(http.MethodGet, "/"))
+}
+
+func TestMockServer_CapturedQuery(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/search", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(s.URLFor("/search?q=mock&page=2&tag=a&tag=b"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	query := s.Interactions.Interaction(http.MethodGet, "/search", 0).CapturedQuery
+	assert.Equal(t, "mock", query.Get("q"))
+	assert.Equal(t, "2", query.Get("page"))
+	assert.Equal(t, []string{"a", "b"}, query["tag"])
+}
+
+func TestMockServer_CapturedBodyAs(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+
+	resp, err := http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"id":7,"item":"book"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var order struct {
+		ID   int    `json:"id"`
+		Item string `json:"item"`
+	}
+	assert.NoError(t, s.Interactions.Interaction(http.MethodPost, "/orders", 0).CapturedBodyAs(&order))
+	assert.Equal(t, 7, order.ID)
+	assert.Equal(t, "book", order.Item)
+
+	assert.Error(t, (&RequestResponse{}).CapturedBodyAs(&order))
+	assert.Error(t, (&RequestResponse{CapturedRequestBody: []byte("not json")}).CapturedBodyAs(&order))
+}
+
+func TestMockServer_CapturedRequests(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/events", http.StatusAccepted, nil, "JSON", nil, option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/events", http.StatusOK, nil, "JSON", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := http.Post(s.URLFor("/events?n="+strconv.Itoa(i)), "application/json", strings.NewReader(`{"n":`+strconv.Itoa(i)+`}`))
+			if assert.NoError(t, err) {
+				_ = resp.Body.Close()
+			}
+		}(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, captured := range s.Interactions.CapturedRequests(http.MethodPost, "/events") {
+				assert.NotEmpty(t, captured.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	captured := s.Interactions.CapturedRequests(http.MethodPost, "/events")
+	assert.Len(t, captured, 1)
+	assert.Equal(t, captured[0].Query.Get("n"), strings.TrimSuffix(strings.TrimPrefix(string(captured[0].Body), `{"n":`), "}"))
+	captured[0].Body[0] = 'x'
+	assert.Equal(t, byte('{'), s.Interactions.CapturedRequests(http.MethodPost, "/events")[0].Body[0])
+	assert.Empty(t, s.Interactions.CapturedRequests(http.MethodGet, "/events"))
+}
+
+func TestMockServer_NotFoundResponse(t *testing.T) {
+	s := StartDefaultHttpServer()
+	resp, err := http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	s.WithNotFoundResponse(http.StatusNotFound, map[string]string{"error": "not found"})
+	resp, err = http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `{"error":"not found"}`, string(body))
+
+	s.WithNotFoundResponse(http.StatusGone, nil)
+	resp, err = http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Empty(t, body)
+}
+
+func TestMockServer_CORS(t *testing.T) {
+	s := StartDefaultHttpServer().WithCORS([]string{"http://app.example"})
+	s.AddInteraction(http.MethodPut, "/items", http.StatusOK, nil, "JSON", nil)
+
+	preflight, _ := http.NewRequest(http.MethodOptions, s.URLFor("/items"), nil)
+	preflight.Header.Set("Origin", "http://app.example")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	preflight.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	resp, err := http.DefaultClient.Do(preflight)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "http://app.example", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPut, resp.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", resp.Header.Get("Access-Control-Allow-Headers"))
+
+	req, _ := http.NewRequest(http.MethodPut, s.URLFor("/items"), nil)
+	req.Header.Set("Origin", "http://app.example")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "http://app.example", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPut, resp.Header.Get("Access-Control-Allow-Methods"))
+
+	preflight.Header.Set("Origin", "http://evil.example")
+	resp, err = http.DefaultClient.Do(preflight)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestMockServer_CompressedRequestBody(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/upload", http.StatusOK, nil, "JSON", nil, option.WithRequestBody([]byte(`{"id":1}`)))
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write([]byte(`{"id":1}`))
+	_ = zw.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, s.URLFor("/upload"), &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []byte(`{"id":1}`), s.Interactions.Interaction(http.MethodPost, "/upload", 0).CapturedRequestBody)
+
+	req, _ = http.NewRequest(http.MethodPost, s.URLFor("/upload"), strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestMockServer_CapturedForm(t *testing.T) {
+	s := StartDefaultHttpServer().WithMultipartMaxMemory(1)
+	s.AddInteraction(http.MethodPost, "/upload", http.StatusCreated, nil, "JSON", nil)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("title", "report")
+	fw, _ := mw.CreateFormFile("file", "report.csv")
+	_, _ = fw.Write([]byte("a,b\n1,2\n"))
+	_ = mw.Close()
+
+	resp, err := http.Post(s.URLFor("/upload"), mw.FormDataContentType(), &body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	form := s.Interactions.Interaction(http.MethodPost, "/upload", 0).CapturedForm
+	if assert.NotNil(t, form) {
+		assert.Equal(t, "report", form.Values.Get("title"))
+		assert.Equal(t, []CapturedFile{{Filename: "report.csv", ContentType: "application/octet-stream", Size: 8}}, form.Files["file"])
+	}
+}
+
+func TestMockServer_Scenario(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/profile", http.StatusOK, map[string]string{"name": "jane"}, "JSON", nil,
+		option.WithScenario("session", "logged in"), option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/profile", http.StatusUnauthorized, nil, "JSON", nil,
+		option.WithScenario("session", option.ScenarioStarted), option.WithPersistent())
+	s.AddInteraction(http.MethodPost, "/login", http.StatusNoContent, nil, "JSON", nil,
+		option.WithScenario("session", option.ScenarioStarted), option.WithNewState("logged in"))
+	s.AddInteraction(http.MethodPost, "/logout", http.StatusNoContent, nil, "JSON", nil,
+		option.WithScenario("session", "logged in"), option.WithNewState(option.ScenarioStarted))
+
+	for _, step := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/profile", http.StatusUnauthorized},
+		{http.MethodPost, "/logout", http.StatusNotImplemented},
+		{http.MethodPost, "/login", http.StatusNoContent},
+		{http.MethodGet, "/profile", http.StatusOK},
+		{http.MethodGet, "/profile", http.StatusOK},
+		{http.MethodPost, "/logout", http.StatusNoContent},
+		{http.MethodGet, "/profile", http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest(step.method, s.URLFor(step.path), nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, step.status, resp.StatusCode, "%s %s", step.method, step.path)
+	}
+	assert.Equal(t, option.ScenarioStarted, s.Interactions.ScenarioState("session"))
+
+	assert.Panics(t, func() {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithNewState("done"))
+	})
+}
+
+func TestMockServer_RemoveAndReplace(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/orders", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/orders/:id", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/users", http.StatusOK, nil, "JSON", nil)
+
+	s.Interactions.Replace(http.MethodGet, "/orders", RequestResponse{ResponseHttpStatus: http.StatusAccepted, Persistent: true})
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(s.URLFor("/orders"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	}
+
+	s.Interactions.Remove(http.MethodGet, "/orders")
+	s.Interactions.Remove(http.MethodGet, "/orders/:id")
+	for _, path := range []string{"/orders", "/orders/1"} {
+		resp, err := http.Get(s.URLFor(path))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	}
+
+	resp, err := http.Get(s.URLFor("/users"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_ResetPath(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/orders", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+	s.AddInteraction(http.MethodGet, "/users", http.StatusOK, nil, "JSON", nil, option.WithPersistent())
+	for _, path := range []string{"/orders", "/users", "/missing"} {
+		_, err := http.Get(s.URLFor(path))
+		assert.NoError(t, err)
+	}
+
+	s.ResetPath(http.MethodGet, "/orders")
+	s.ResetPath(http.MethodGet, "/missing")
+	assert.Equal(t, 0, s.Interactions.CallCount(http.MethodGet, "/orders"))
+	assert.Equal(t, 1, s.Interactions.CallCount(http.MethodGet, "/users"))
+	assert.Empty(t, s.UnmatchedRequests())
+
+	resp, err := http.Get(s.URLFor("/orders"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	resp, err = http.Get(s.URLFor("/users"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_Builder(t *testing.T) {
+	s := StartDefaultHttpServer()
+	var captured []byte
+	s.On(http.MethodPost, "/orders").
+		RespondWith(http.StatusCreated, map[string]int{"id": 1}).
+		WithHeader("X-Request-Id", "abc").
+		WithHeader("X-Request-Id", "def").
+		Delay(10 * time.Millisecond).
+		Capture(func(body []byte, _ http.Header) { captured = body }).
+		Register()
+
+	resp, err := http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"item":"book"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []string{"abc", "def"}, resp.Header.Values("X-Request-Id"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `{"id":1}`, string(body))
+	assert.Equal(t, `{"item":"book"}`, string(captured))
+
+	s.On(http.MethodGet, "/health").With(option.WithTimes(2)).Register()
+	for i := 0; i < 2; i++ {
+		resp, err = http.Get(s.URLFor("/health"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestMockServer_ResponseForAttempt(t *testing.T) {
+	s := StartDefaultHttpServer()
+	get := func(path string) int {
+		resp, err := http.Get(s.URLFor(path))
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	s.AddInteraction(http.MethodGet, "/flaky", http.StatusOK, nil, "JSON", nil,
+		option.WithResponseForAttempt(0, http.StatusServiceUnavailable, nil),
+		option.WithResponseForAttempt(1, http.StatusServiceUnavailable, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/flaky"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/flaky"))
+	assert.Equal(t, http.StatusNotImplemented, get("/flaky"))
+
+	s.AddInteraction(http.MethodGet, "/recovering", http.StatusServiceUnavailable, nil, "JSON", nil,
+		option.WithResponseForAttempt(2, http.StatusOK, nil), option.WithRepeatLastAttempt())
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		assert.Equal(t, status, get("/recovering"))
+	}
+}
+
+func TestMockServer_WaitForCall(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/events", http.StatusAccepted, nil, "JSON", nil)
+
+	_, err := s.WaitForCall(http.MethodPost, "/events", 50*time.Millisecond)
+	assert.Error(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		resp, err := http.Post(s.URLFor("/events"), "application/json", strings.NewReader(`{"type":"created"}`))
+		if assert.NoError(t, err) {
+			_ = resp.Body.Close()
+		}
+	}()
+	captured, err := s.WaitForCall(http.MethodPost, "/events", 5*time.Second)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"type":"created"}`, string(captured.CapturedRequestBody))
+	}
+}
+
+func TestMockServer_Hang(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/slow", http.StatusOK, nil, "JSON", nil, option.WithHang(), option.WithPersistent())
+
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	_, err := client.Get(s.URLFor("/slow"))
+	assert.Error(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := http.Get(s.URLFor("/slow"))
+		errs <- err
+	}()
+	_, err = s.WaitForCall(http.MethodGet, "/slow", time.Second)
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.NoError(t, s.ShutdownE(ctx))
+	assert.Error(t, <-errs)
+}
+
+func TestMockServer_SSEEvents(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/events", http.StatusOK, nil, "JSON", nil, option.WithSSEEvents([]option.SSEEvent{
+		{ID: "1", Event: "greeting", Data: "hello"},
+		{ID: "2", Data: "line one\nline two", Delay: 100 * time.Millisecond},
+	}))
+
+	start := time.Now()
+	resp, err := http.Get(s.URLFor("/events"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	var first []string
+	for line, err := reader.ReadString('\n'); err == nil && line != "\n"; line, err = reader.ReadString('\n') {
+		first = append(first, line)
+	}
+	assert.Equal(t, []string{"id: 1\n", "event: greeting\n", "data: hello\n"}, first)
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	rest, _ := ioutil.ReadAll(reader)
+	_ = resp.Body.Close()
+	assert.Equal(t, "id: 2\ndata: line one\ndata: line two\n\n", string(rest))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestMockServer_StreamedBody(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/stream", http.StatusOK, nil, "text/plain", nil,
+		option.WithStreamedBody([][]byte{[]byte("first,"), []byte("second,"), []byte("third")}, 100*time.Millisecond))
+
+	start := time.Now()
+	resp, err := http.Get(s.URLFor("/stream"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+	first := make([]byte, len("first,"))
+	_, err = io.ReadFull(resp.Body, first)
+	assert.NoError(t, err)
+	assert.Equal(t, "first,", string(first))
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	rest, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "second,third", string(rest))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+}
+
+func TestMockServer_GzipResponse(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, map[string]string{"hello": "world"}, "JSON", nil,
+		option.WithGzipResponse(), option.WithTimes(3))
+
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.True(t, resp.Uncompressed)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `{"hello":"world"}`, string(body))
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL(), nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	compressed, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, int64(len(compressed)), resp.ContentLength)
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if assert.NoError(t, err) {
+		body, _ = ioutil.ReadAll(zr)
+		assert.JSONEq(t, `{"hello":"world"}`, string(body))
+	}
+
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+}
+
+func TestMockServer_ResponseTemplate(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/users/:team", http.StatusCreated, nil, "JSON", nil,
+		option.WithResponseTemplate(`{"id":{{.Body.id}},"team":"{{.PathParams.team}}","source":"{{.Query.Get "source"}}"}`))
+
+	resp, err := http.Post(s.URLFor("/users/blue?source=test"), "application/json", strings.NewReader(`{"id":42}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `{"id":42,"team":"blue","source":"test"}`, string(body))
+
+	assert.Panics(t, func() {
+		s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithResponseTemplate("{{.Body"))
+	})
+}
+
+func TestMockServer_Representations(t *testing.T) {
+	type greeting struct {
+		Text string `json:"text" xml:"text"`
+	}
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/greeting", http.StatusOK, nil, "JSON", nil, option.WithPersistent(),
+		option.WithRepresentations(map[string]interface{}{
+			"JSON":       greeting{Text: "hello"},
+			"XML":        greeting{Text: "hello"},
+			"text/plain": "hello",
+		}))
+
+	for accept, expected := range map[string]string{
+		"":                                  `{"text":"hello"}`,
+		"*/*":                               `{"text":"hello"}`,
+		"application/xml":                   `<greeting><text>hello</text></greeting>`,
+		"text/*":                            `hello`,
+		"application/xml;q=0.5, text/plain": `hello`,
+		"image/png":                         `{"text":"hello"}`,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, s.URLFor("/greeting"), nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, expected, string(body), accept)
+	}
+}
+
+func TestMockServer_RequestSchema(t *testing.T) {
+	schema := []byte(`{
+		"type": "object",
+		"required": ["id", "items"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"items": {"type": "array", "minItems": 1, "items": {"type": "string", "enum": ["book", "pen"]}}
+		}
+	}`)
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil, option.WithRequestSchema(schema), option.WithPersistent())
+
+	resp, err := http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"id":1,"items":["book"]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"id":0,"items":["cup"],"note":"x"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var errResp struct {
+		Errors []string `json:"errors"`
+	}
+	assert.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(&errResp))
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{
+		"$.id: value 0 is less than 1",
+		`$.items[0]: value cup is not one of [book pen]`,
+		`$: property "note" is not allowed`,
+	}, errResp.Errors)
+	assert.Equal(t, 1, s.Interactions.CallCount(http.MethodPost, "/orders"))
+
+	assert.Panics(t, func() {
+		s.AddInteraction(http.MethodPost, "/", http.StatusOK, nil, "JSON", nil, option.WithRequestSchema([]byte("{")))
+	})
+}
+
+func TestMockServer_LoadOpenAPI(t *testing.T) {
+	spec := `openapi: 3.0.0
+info:
+  title: Pets
+  version: "1"
+paths:
+  /pets:
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: "#/components/schemas/Pet"
+    post:
+      responses:
+        "400":
+          description: invalid
+        "201":
+          content:
+            application/json:
+              example: {id: 2, name: rex}
+  /pets/{id}:
+    parameters:
+      - name: id
+        in: path
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              examples:
+                cat:
+                  value: {id: 1, name: tom}
+    delete:
+      responses:
+        "404":
+          description: missing
+  /files/{name}.json:
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              example: {format: json}
+  /{name}-{version}:
+    get:
+      responses:
+        "200":
+          content:
+            text/plain:
+              example: artifact
+components:
+  schemas:
+    Pet:
+      type: object
+      properties:
+        id: {type: integer, example: 1}
+        name: {type: string, default: tom}
+`
+	file := filepath.Join(t.TempDir(), "pets.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(spec), 0o600))
+
+	s := StartDefaultHttpServer()
+	assert.NoError(t, s.LoadOpenAPI(file))
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/pets", http.StatusOK, `[{"id":1,"name":"tom"}]`},
+		{http.MethodPost, "/pets", http.StatusCreated, `{"id":2,"name":"rex"}`},
+		{http.MethodGet, "/pets/7", http.StatusOK, `{"id":1,"name":"tom"}`},
+		{http.MethodGet, "/pets/8", http.StatusOK, `{"id":1,"name":"tom"}`},
+		{http.MethodGet, "/files/report.json", http.StatusOK, `{"format":"json"}`},
+	} {
+		req, _ := http.NewRequest(tc.method, s.URLFor(tc.path), nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.status, resp.StatusCode, "%s %s", tc.method, tc.path)
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.JSONEq(t, tc.body, string(body), "%s %s", tc.method, tc.path)
+	}
+
+	var params map[string]string
+	s.WithOnMatch(func(rr *RequestResponse) { params = rr.PathParams })
+	resp, err := http.Get(s.URLFor("/httpmock-1.2"))
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "artifact", string(body))
+	assert.Equal(t, map[string]string{"name": "httpmock", "version": "1.2"}, params)
+
+	req, _ := http.NewRequest(http.MethodDelete, s.URLFor("/pets/1"), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	assert.Error(t, s.LoadOpenAPI(filepath.Join(t.TempDir(), "missing.yaml")))
+}
+
+func TestMockServer_LoadWireMock(t *testing.T) {
+	mappings := `{"mappings": [
+		{
+			"request": {"method": "GET", "url": "/search?q=books", "headers": {"Accept": {"equalTo": "application/json"}}},
+			"response": {"status": 200, "jsonBody": {"results": ["go"]}, "headers": {"X-Source": "wiremock"}}
+		},
+		{
+			"request": {
+				"method": "POST",
+				"urlPath": "/orders",
+				"bodyPatterns": [{"equalToJson": "{\"item\": \"book\"}"}, {"matchesJsonPath": "$.item"}]
+			},
+			"response": {"status": 201, "body": "created", "headers": {"Content-Type": "text/plain"}}
+		},
+		{
+			"request": {"method": "DELETE", "urlPathPattern": "/orders/[0-9]+"},
+			"response": {"status": 204}
+		},
+		{
+			"request": {"method": "ANY", "url": "/anything"},
+			"response": {"status": 200}
+		}
+	]}`
+	file := filepath.Join(t.TempDir(), "mappings.json")
+	assert.NoError(t, os.WriteFile(file, []byte(mappings), 0o600))
+
+	s := StartDefaultHttpServer()
+	assert.NoError(t, s.LoadWireMock(file))
+
+	req, _ := http.NewRequest(http.MethodGet, s.URLFor("/search?q=books"), nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "wiremock", resp.Header.Get("X-Source"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `{"results":["go"]}`, string(body))
+
+	resp, err = http.Get(s.URLFor("/search?q=films"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	resp, err = http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{ "item" : "book" }`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	body, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "created", string(body))
+
+	resp, err = http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"item":"pen"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodDelete, s.URLFor("/orders/12"), nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	assert.Error(t, s.LoadWireMock(filepath.Join(t.TempDir(), "missing.json")))
+}
+
+func TestMockServer_AdminRequests(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+
+	before := time.Now()
+	_, err := http.Post(s.URLFor("/orders?dry=true"), "application/json", strings.NewReader(`{"id":1}`))
+	assert.NoError(t, err)
+	_, err = http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+
+	resp, err := http.Get(s.URLFor("/__admin/requests"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var logged []LoggedRequest
+	assert.NoError(t, jsoniter.NewDecoder(resp.Body).Decode(&logged))
+	_ = resp.Body.Close()
+
+	if assert.Len(t, logged, 2) {
+		assert.Equal(t, http.MethodPost, logged[0].Method)
+		assert.Equal(t, "/orders", logged[0].Path)
+		assert.Equal(t, "dry=true", logged[0].Query)
+		assert.Equal(t, `{"id":1}`, logged[0].Body)
+		assert.Equal(t, "application/json", logged[0].Headers.Get("Content-Type"))
+		assert.True(t, logged[0].Matched)
+		assert.False(t, logged[0].Received.Before(before.Truncate(time.Second)))
+		assert.Equal(t, "/missing", logged[1].Path)
+		assert.False(t, logged[1].Matched)
+	}
+	assert.Len(t, s.RequestLog(), 2)
+}
+
+func TestMockServer_RequestLogIncludesRejectedRequests(t *testing.T) {
+	s := NewServer().WithBasicAuth("user", "secret").Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil,
+		option.WithRequestSchema([]byte(`{"type":"object","required":["id"]}`)))
+
+	resp, err := http.Post(s.URLFor("/orders"), "application/json", strings.NewReader(`{"id":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodPost, s.URLFor("/orders"), strings.NewReader(`{}`))
+	req.SetBasicAuth("user", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	logged := s.RequestLog()
+	if assert.Len(t, logged, 2) {
+		assert.Equal(t, "/orders", logged[0].Path)
+		assert.False(t, logged[0].Matched)
+		assert.Equal(t, `{}`, logged[1].Body)
+		assert.False(t, logged[1].Matched)
+	}
+}
+
+func TestMockServer_AdminResetAndInteractions(t *testing.T) {
+	s := StartDefaultHttpServer()
+
+	resp, err := http.Post(s.URLFor("/__admin/interactions"), "application/json",
+		strings.NewReader(`{"method":"GET","path":"/health","status":200,"body":{"status":"up"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = http.Post(s.URLFor("/__admin/interactions"), "application/json",
+		strings.NewReader(`[{"method":"GET","path":"/a","status":204},{"method":"GET","path":"/b","status":204}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = http.Get(s.URLFor("/health"))
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.JSONEq(t, `{"status":"up"}`, string(body))
+	resp, err = http.Get(s.URLFor("/a"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(s.URLFor("/__admin/interactions"), "application/json", strings.NewReader(`{"path":"/c","status":200}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(s.URLFor("/__admin/reset"), "application/json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, s.RequestLog())
+	resp, err = http.Get(s.URLFor("/b"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestMockServer_CapturedAt(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil, option.WithResponseDelay(50*time.Millisecond))
+
+	before := time.Now()
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	interaction := s.Interactions.AllInteractions(http.MethodGet, "/")[0]
+	assert.False(t, interaction.CapturedAt.Before(before))
+	assert.True(t, interaction.CapturedAt.Before(time.Now()))
+	assert.GreaterOrEqual(t, int64(interaction.Elapsed), int64(50*time.Millisecond))
+}
+
+func TestMockServer_CaptureLimit(t *testing.T) {
+	s := StartDefaultHttpServer()
+	s.AddInteraction(http.MethodPost, "/events", http.StatusAccepted, nil, "JSON", nil, option.WithPersistent(), option.WithCaptureLimit(3))
+	for i := 0; i < 3; i++ {
+		s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil, option.WithCaptureLimit(2))
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := http.Post(s.URLFor("/events"), "text/plain", strings.NewReader(strconv.Itoa(i)))
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := http.Post(s.URLFor("/orders"), "text/plain", strings.NewReader(strconv.Itoa(i)))
+		assert.NoError(t, err)
+	}
+
+	var bodies []string
+	for _, captured := range s.Interactions.CapturedRequests(http.MethodPost, "/events") {
+		bodies = append(bodies, string(captured.Body))
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, bodies)
+
+	bodies = nil
+	for _, captured := range s.Interactions.CapturedRequests(http.MethodPost, "/orders") {
+		bodies = append(bodies, string(captured.Body))
+	}
+	assert.Equal(t, []string{"1", "2"}, bodies)
+	assert.Nil(t, s.Interactions.Interaction(http.MethodPost, "/orders", 0).CapturedRequestBody)
+	assert.Equal(t, []byte("2"), s.Interactions.Interaction(http.MethodPost, "/orders", 2).CapturedRequestBody)
+}
+
+func TestMockServer_Hooks(t *testing.T) {
+	var events []string
+	s := StartDefaultHttpServer().
+		WithOnRequest(func(r *http.Request, body []byte) { events = append(events, "request "+r.URL.Path+" "+string(body)) }).
+		WithOnRequest(func(r *http.Request, _ []byte) { events = append(events, "second request hook") }).
+		WithOnMatch(func(rr *RequestResponse) { events = append(events, "match "+rr.Path) }).
+		WithOnNoMatch(func(r *http.Request) { events = append(events, "no match "+r.URL.Path) })
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+
+	_, err := http.Post(s.URLFor("/orders"), "text/plain", strings.NewReader("order"))
+	assert.NoError(t, err)
+	_, err = http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"request /orders order",
+		"second request hook",
+		"match /orders",
+		"request /missing ",
+		"second request hook",
+		"no match /missing",
+	}, events)
+}
+
+func TestMockServer_RequestBodyRestored(t *testing.T) {
+	var body []byte
+	s := StartDefaultHttpServer().WithOnNoMatch(func(r *http.Request) { body, _ = ioutil.ReadAll(r.Body) })
+	defer s.Shutdown()
+
+	_, err := http.Post(s.URLFor("/missing"), "text/plain", strings.NewReader("order"))
+	assert.NoError(t, err)
+	assert.Equal(t, "order", string(body))
+}
+
+func TestMockServer_PanickingCaptureFunc(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", func(body []byte, headers http.Header) {
+		panic("unexpected body")
+	})
+	s.AddInteraction(http.MethodPost, "/orders", http.StatusCreated, nil, "JSON", nil)
+
+	resp, err := http.Post(s.URLFor("/orders"), "text/plain", strings.NewReader("order"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp, err = http.Post(s.URLFor("/orders"), "text/plain", strings.NewReader("order"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestMockServer_AutoHead(t *testing.T) {
+	s := StartDefaultHttpServer()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/items/1", http.StatusOK, map[string]string{"name": "foo"}, "JSON", nil,
+		option.WithPersistent(), option.WithResponseHeaders(http.Header{"ETag": {"v1"}}))
+
+	resp, err := http.Head(s.URLFor("/items/1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	s.WithAutoHead()
+	resp, err = http.Head(s.URLFor("/items/1"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "v1", resp.Header.Get("ETag"))
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Empty(t, body)
+
+	resp, err = http.Head(s.URLFor("/items/2"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestMockServer_AutoOptions(t *testing.T) {
+	s := StartDefaultHttpServer().WithAutoOptions()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/items", http.StatusOK, nil, "JSON", nil)
+	s.AddInteraction(http.MethodPost, "/items", http.StatusCreated, nil, "JSON", nil)
+	s.AddInteraction(http.MethodDelete, "/items/:id", http.StatusNoContent, nil, "JSON", nil)
+
+	options := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodOptions, s.URLFor(path), nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := options("/items")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "GET, OPTIONS, POST", resp.Header.Get("Allow"))
+
+	resp = options("/items/1")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "DELETE, OPTIONS", resp.Header.Get("Allow"))
+
+	resp = options("/missing")
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	s.WithAutoHead()
+	resp = options("/items")
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", resp.Header.Get("Allow"))
+}
+
+func TestMockServer_WithMiddleware(t *testing.T) {
+	var order []string
+	s := NewServer().WithMiddleware(
+		func(c *gin.Context) {
+			order = append(order, "first")
+			c.Header("X-Request-Tag", "tagged")
+		},
+		func(c *gin.Context) {
+			order = append(order, "second")
+			if c.GetHeader("Authorization") == "" {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}).WithOnRequest(func(*http.Request, []byte) { order = append(order, "mock") }).Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "tagged", resp.Header.Get("X-Request-Tag"))
+	assert.Equal(t, []string{"first", "second"}, order)
+
+	order = nil
+	req, _ := http.NewRequest(http.MethodGet, s.URL(), nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"first", "second", "mock"}, order)
+
+	resp, err = http.Get(s.URLFor("/__admin/requests"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_BasicAuth(t *testing.T) {
+	s := NewServer().WithBasicAuth("user", "secret").Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	for _, credentials := range [][]string{nil, {"user", "wrong"}, {"other", "secret"}} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL(), nil)
+		if credentials != nil {
+			req.SetBasicAuth(credentials[0], credentials[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, `Basic realm="httpmock"`, resp.Header.Get("WWW-Authenticate"))
+	}
+	assert.Equal(t, 0, s.Interactions.CallCount(http.MethodGet, "/"))
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL(), nil)
+	req.SetBasicAuth("user", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_SilentAndLogLevel(t *testing.T) {
+	var ginOutput bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &ginOutput
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	s := NewServer().WithLogger(zap.New(core)).WithLogLevel(zapcore.WarnLevel).Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+	logs.TakeAll()
+
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = http.Get(s.URLFor("/missing"))
+	assert.NoError(t, err)
+
+	assert.Zero(t, logs.FilterLevelExact(zapcore.InfoLevel).Len())
+	assert.NotZero(t, logs.FilterLevelExact(zapcore.WarnLevel).Len())
+	assert.NotContains(t, ginOutput.String(), "| 200 |")
+
+	silent := NewServer().WithSilent().Start()
+	defer silent.Shutdown()
+	silent.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+	resp, err = http.Get(silent.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, ginOutput.String(), "| 200 |")
+}
+
+func TestMockServer_GinMode(t *testing.T) {
+	mode := gin.Mode()
+	defer gin.SetMode(mode)
+
+	var ginOutput bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &ginOutput
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	tagged := false
+	s := NewServer().WithGinMode(gin.ReleaseMode, func(c *gin.Context) { tagged = true }).Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+
+	resp, err := http.Get(s.URL())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, gin.ReleaseMode, gin.Mode())
+	assert.True(t, tagged)
+	assert.Empty(t, ginOutput.String())
+}
+
+func TestMockServer_Err(t *testing.T) {
+	s := StartDefaultHttpServer()
+
+	// stopping the http.Server directly leaves its serve error buffered, and a closed server is no runtime error
+	assert.NoError(t, s.httpServer.Shutdown(context.Background()))
+	select {
+	case err := <-s.errorChannel:
+		assert.ErrorIs(t, err, http.ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Error("serve error was not sent")
+	}
+	select {
+	case err := <-s.Err():
+		t.Errorf("unexpected runtime error %v", err)
+	default:
+	}
+}
+
+func TestMockServer_WithHost(t *testing.T) {
+	s := StartDefaultHttpServer()
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", s.Port), s.httpServer.Addr)
+	s.Shutdown()
+
+	s = NewServer().WithHost("0.0.0.0").Start()
+	defer s.Shutdown()
+	s.AddInteraction(http.MethodGet, "/", http.StatusOK, nil, "JSON", nil)
+	assert.Equal(t, fmt.Sprintf("0.0.0.0:%d", s.Port), s.httpServer.Addr)
+	assert.Equal(t, fmt.Sprintf("http://localhost:%d", s.Port), s.URL())
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", s.Port))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestMockServer_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "mock.sock")
+	s := NewServer().WithUnixSocket(socket).Start()
+	s.AddInteraction(http.MethodGet, "/items", http.StatusOK, map[string]string{"name": "foo"}, "JSON", nil)
+
+	assert.Equal(t, socket, s.SocketPath())
+	assert.Equal(t, 0, s.Port)
+	resp, err := s.Client().Get(s.URLFor("/items"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, `{"name":"foo"}`, string(body))
+	_ = resp.Body.Close()
+
+	s.Shutdown()
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMockServer_ShutdownWithoutStart(t *testing.T) {
+	hookCalled := false
+	s := NewServer().OnShutdown(func() { hookCalled = true })
+
+	assert.NotPanics(t, s.Shutdown)
+	assert.ErrorIs(t, s.ShutdownE(context.Background()), ErrNotStarted)
+	assert.False(t, hookCalled)
+}
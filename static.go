@@ -0,0 +1,79 @@
+package httpmock
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type staticDir struct {
+	prefix string
+	dir    string
+}
+
+// ServeDir serves GET requests whose path starts with prefix from the files in dir, using the rest of the path as
+// the file name. Missing files are answered with 404, and paths escaping dir are rejected.
+// Registered interactions take precedence over the directory.
+func (s *Server) ServeDir(prefix string, dir string) *Server {
+	s.staticLock.Lock()
+	defer s.staticLock.Unlock()
+	s.staticDirs = append(s.staticDirs, staticDir{prefix: strings.TrimSuffix(prefix, "/") + "/", dir: dir})
+	return s
+}
+
+func (s *Server) staticDirFor(r *http.Request) (staticDir, bool) {
+	if r.Method != http.MethodGet {
+		return staticDir{}, false
+	}
+
+	s.staticLock.RLock()
+	defer s.staticLock.RUnlock()
+	for _, sd := range s.staticDirs {
+		if strings.HasPrefix(r.URL.Path, sd.prefix) {
+			return sd, true
+		}
+	}
+	return staticDir{}, false
+}
+
+func (s *Server) serveFile(c *gin.Context, sd staticDir) {
+	subPath := path.Clean("/" + strings.TrimPrefix(c.Request.URL.Path, sd.prefix))
+	root, err := filepath.Abs(sd.dir)
+	if err != nil {
+		s.logger.Error("failed to resolve static directory", zap.String("dir", sd.dir), zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	file := filepath.Join(root, filepath.FromSlash(subPath))
+	if rel, err := filepath.Rel(root, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		s.logger.Warn("rejecting static file request outside of directory", zap.String("path", c.Request.URL.Path))
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		s.logger.Info("static file not found", zap.String("file", file))
+		c.Status(http.StatusNotFound)
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		s.logger.Error("failed to read static file", zap.String("file", file), zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	s.logger.Info("responding with static file", zap.String("file", file), zap.String("contentType", contentType))
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,82 @@
+package httpmock
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// writeSSE streams the server-sent events of the interaction, flushing each event once its delay has passed.
+func (s *Server) writeSSE(c *gin.Context, mock *RequestResponse) {
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	c.Status(mock.ResponseHttpStatus)
+	c.Writer.Flush()
+
+	s.logger.Info("streaming server-sent events", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.Int("events", len(mock.SSEEvents)))
+	for _, event := range mock.SSEEvents {
+		if !s.pause(c, event.Delay) {
+			s.logger.Info("client disconnected from event stream")
+			return
+		}
+		var b strings.Builder
+		if event.ID != "" {
+			_, _ = fmt.Fprintf(&b, "id: %s\n", event.ID)
+		}
+		if event.Event != "" {
+			_, _ = fmt.Fprintf(&b, "event: %s\n", event.Event)
+		}
+		for _, line := range strings.Split(event.Data, "\n") {
+			_, _ = fmt.Fprintf(&b, "data: %s\n", line)
+		}
+		b.WriteString("\n")
+		if _, err := c.Writer.WriteString(b.String()); err != nil {
+			s.logger.Info("failed to write event", zap.Error(err))
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// pause waits for d and returns false if the client disconnects or the server shuts down before.
+func (s *Server) pause(c *gin.Context, d time.Duration) bool {
+	if d <= 0 {
+		return c.Request.Context().Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	case <-s.closing:
+		return false
+	}
+}
+
+// writeStream writes the streamed chunks of the interaction, flushing each chunk so that it is sent as
+// its own HTTP chunk, and waits for the stream interval between chunks.
+func (s *Server) writeStream(c *gin.Context, mock *RequestResponse) {
+	c.Writer.Header().Set("Content-Type", contentType(mock.ResponseContentType))
+	c.Status(mock.ResponseHttpStatus)
+	c.Writer.Flush()
+
+	s.logger.Info("streaming response body", zap.Int("httpStatus", mock.ResponseHttpStatus), zap.Int("chunks", len(mock.StreamedChunks)))
+	for i, chunk := range mock.StreamedChunks {
+		if i > 0 && !s.pause(c, mock.StreamInterval) {
+			s.logger.Info("client disconnected from streamed response")
+			return
+		}
+		if _, err := c.Writer.Write(chunk); err != nil {
+			s.logger.Info("failed to write chunk", zap.Error(err))
+			return
+		}
+		c.Writer.Flush()
+	}
+}
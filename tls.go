@@ -0,0 +1,103 @@
+package httpmock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithTLS serves HTTPS using the PEM encoded certificate and key.
+// When both are nil a self-signed certificate for localhost is generated on Start.
+func (s *Server) WithTLS(certPEM []byte, keyPEM []byte) *Server {
+	s.tls = true
+	if certPEM == nil && keyPEM == nil {
+		return s
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		s.logger.Panic("invalid TLS certificate or key", zap.Error(err))
+	}
+	s.setCertificate(cert)
+	return s
+}
+
+// StartTLS starts the server with HTTPS, using a self-signed certificate unless WithTLS provided one.
+func (s *Server) StartTLS() *Server {
+	s.tls = true
+	return s.Start()
+}
+
+// Certificate returns the certificate served over TLS, or nil when TLS is not enabled.
+// Add it to the root CAs of a client to trust the server.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.certificate
+}
+
+// CertPool returns a pool containing only the server certificate, or nil when TLS is not enabled.
+func (s *Server) CertPool() *x509.CertPool {
+	if s.certificate == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(s.certificate)
+	return pool
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.certificate == nil {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		s.setCertificate(cert)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{s.tlsCertificate}}, nil
+}
+
+func (s *Server) setCertificate(cert tls.Certificate) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		s.logger.Panic("failed to parse TLS certificate", zap.Error(err))
+	}
+	s.tlsCertificate = cert
+	s.certificate = leaf
+}
+
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"httpmock"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,50 @@
+package httpmock
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// WithUnixSocket makes Start listen on a unix domain socket at path instead of a TCP port. Port stays 0 and URL
+// returns http://localhost without a port; use Client, or dial SocketPath, to reach the server.
+// The socket file is removed on Shutdown.
+func (s *Server) WithUnixSocket(path string) *Server {
+	s.unixSocket = path
+	return s
+}
+
+// SocketPath returns the path of the unix domain socket the server listens on, or "" when it listens on TCP.
+func (s *Server) SocketPath() string {
+	return s.unixSocket
+}
+
+// Client returns an http.Client that reaches the server, dialing its unix domain socket if WithUnixSocket is used
+// and trusting its certificate if TLS is enabled.
+func (s *Server) Client() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.unixSocket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", s.unixSocket)
+		}
+	}
+	if pool := s.CertPool(); pool != nil {
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	return &http.Client{Transport: transport}
+}
+
+// removeSocket deletes the socket file left behind by the server, if any.
+func (s *Server) removeSocket() {
+	if s.unixSocket == "" {
+		return
+	}
+	if err := os.Remove(s.unixSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Warn("failed to remove unix socket", zap.String("path", s.unixSocket), zap.Error(err))
+	}
+}
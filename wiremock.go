@@ -0,0 +1,215 @@
+package httpmock
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/httpmock/option"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"go.uber.org/zap"
+)
+
+type wireMockFile struct {
+	Mappings []wireMockMapping `json:"mappings"`
+}
+
+type wireMockMapping struct {
+	Request  wireMockRequest  `json:"request"`
+	Response wireMockResponse `json:"response"`
+}
+
+type wireMockRequest struct {
+	Method          string                            `json:"method"`
+	URL             string                            `json:"url"`
+	URLPath         string                            `json:"urlPath"`
+	URLPattern      string                            `json:"urlPattern"`
+	URLPathPattern  string                            `json:"urlPathPattern"`
+	Headers         map[string]map[string]interface{} `json:"headers"`
+	QueryParameters map[string]map[string]interface{} `json:"queryParameters"`
+	BodyPatterns    []map[string]interface{}          `json:"bodyPatterns"`
+}
+
+type wireMockResponse struct {
+	Status                 int               `json:"status"`
+	Body                   *string           `json:"body"`
+	JSONBody               interface{}       `json:"jsonBody"`
+	Headers                map[string]string `json:"headers"`
+	FixedDelayMilliseconds int64             `json:"fixedDelayMilliseconds"`
+}
+
+// LoadWireMock registers the WireMock stub mappings in the JSON file at path, which holds either a single mapping or
+// an object with a "mappings" array. The request method, url, urlPath, urlPathPattern, equalTo header and query
+// parameter matchers and the equalTo, equalToJson, contains and matches body patterns are supported, as are the
+// response status, body, jsonBody, headers and fixedDelayMilliseconds. Unsupported matchers are logged and skipped,
+// and so are mappings that can't be translated at all. Like in WireMock, the interactions are persistent.
+func (s *Server) LoadWireMock(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read WireMock mappings %s: %w", path, err)
+	}
+	var file wireMockFile
+	if err := jsoniter.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse WireMock mappings %s: %w", path, err)
+	}
+	if file.Mappings == nil {
+		var mapping wireMockMapping
+		if err := jsoniter.Unmarshal(data, &mapping); err != nil {
+			return fmt.Errorf("failed to parse WireMock mapping %s: %w", path, err)
+		}
+		file.Mappings = []wireMockMapping{mapping}
+	}
+
+	for i, mapping := range file.Mappings {
+		if err := s.addWireMockMapping(mapping); err != nil {
+			s.logger.Warn("skipping WireMock mapping", zap.Int("index", i), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *Server) addWireMockMapping(mapping wireMockMapping) error {
+	req, resp := mapping.Request, mapping.Response
+	method := strings.ToUpper(req.Method)
+	if method == "" || method == "ANY" {
+		return fmt.Errorf("unsupported method %q", req.Method)
+	}
+
+	opts := []option.HttpMockOptionFunc{option.WithPersistent()}
+	query := map[string]string{}
+	var path string
+	switch {
+	case req.URLPath != "":
+		path = req.URLPath
+	case req.URL != "":
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url %q: %w", req.URL, err)
+		}
+		path = u.Path
+		for name, values := range u.Query() {
+			query[name] = values[0]
+		}
+	case req.URLPathPattern != "":
+		if _, err := regexp.Compile(req.URLPathPattern); err != nil {
+			return fmt.Errorf("invalid urlPathPattern %q: %w", req.URLPathPattern, err)
+		}
+		path = req.URLPathPattern
+		opts = append(opts, option.WithPathRegex("^(?:"+req.URLPathPattern+")$"))
+	default:
+		return fmt.Errorf("unsupported url matcher in %+v", req)
+	}
+
+	for name, matcher := range req.QueryParameters {
+		if value, ok := equalToMatcher(matcher); ok {
+			query[name] = value
+		} else {
+			s.logger.Warn("skipping unsupported WireMock query parameter matcher", zap.String("name", name), zap.Any("matcher", matcher))
+		}
+	}
+	if len(query) > 0 {
+		opts = append(opts, option.WithQueryParams(query))
+	}
+
+	headers := http.Header{}
+	for name, matcher := range req.Headers {
+		if value, ok := equalToMatcher(matcher); ok {
+			headers.Set(name, value)
+		} else {
+			s.logger.Warn("skipping unsupported WireMock header matcher", zap.String("name", name), zap.Any("matcher", matcher))
+		}
+	}
+	if len(headers) > 0 {
+		opts = append(opts, option.WithRequestHeaders(headers))
+	}
+
+	var matchers []func([]byte) bool
+	for _, pattern := range req.BodyPatterns {
+		if matcher := s.wireMockBodyMatcher(pattern); matcher != nil {
+			matchers = append(matchers, matcher)
+		}
+	}
+	if len(matchers) > 0 {
+		opts = append(opts, option.WithBodyMatcher(func(body []byte) bool {
+			for _, matcher := range matchers {
+				if !matcher(body) {
+					return false
+				}
+			}
+			return true
+		}))
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := "JSON"
+	responseHeaders := http.Header{}
+	for name, value := range resp.Headers {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+			continue
+		}
+		responseHeaders.Set(name, value)
+	}
+	if len(responseHeaders) > 0 {
+		opts = append(opts, option.WithResponseHeaders(responseHeaders))
+	}
+	if resp.Body != nil {
+		if contentType == "JSON" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		opts = append(opts, option.WithRawResponseBody([]byte(*resp.Body)))
+	}
+	if resp.FixedDelayMilliseconds > 0 {
+		opts = append(opts, option.WithResponseDelay(time.Duration(resp.FixedDelayMilliseconds)*time.Millisecond))
+	}
+
+	s.AddInteraction(method, path, status, resp.JSONBody, contentType, nil, opts...)
+	return nil
+}
+
+// wireMockBodyMatcher translates a WireMock body pattern, returning nil for unsupported ones.
+func (s *Server) wireMockBodyMatcher(pattern map[string]interface{}) func([]byte) bool {
+	if expected, ok := pattern["equalTo"].(string); ok {
+		return func(body []byte) bool { return string(body) == expected }
+	}
+	if expected, ok := pattern["equalToJson"]; ok {
+		if str, isString := expected.(string); isString {
+			if err := jsoniter.Unmarshal([]byte(str), &expected); err != nil {
+				s.logger.Warn("skipping invalid WireMock equalToJson body pattern", zap.Error(err))
+				return nil
+			}
+		}
+		return func(body []byte) bool {
+			var actual interface{}
+			return jsoniter.Unmarshal(body, &actual) == nil && reflect.DeepEqual(expected, actual)
+		}
+	}
+	if substring, ok := pattern["contains"].(string); ok {
+		return func(body []byte) bool { return bytes.Contains(body, []byte(substring)) }
+	}
+	if expr, ok := pattern["matches"].(string); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			s.logger.Warn("skipping invalid WireMock matches body pattern", zap.String("pattern", expr), zap.Error(err))
+			return nil
+		}
+		return re.Match
+	}
+	s.logger.Warn("skipping unsupported WireMock body pattern", zap.Any("pattern", pattern))
+	return nil
+}
+
+// equalToMatcher returns the value of a WireMock equalTo matcher.
+func equalToMatcher(matcher map[string]interface{}) (string, bool) {
+	value, ok := matcher["equalTo"].(string)
+	return value, ok && len(matcher) == 1
+}